@@ -0,0 +1,200 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+const (
+	// DefaultLogFormat is the nginx "combined" log_format followed by $request_time
+	DefaultLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time`
+	// DefaultMeasurement is the measurement of points built from access logs
+	DefaultMeasurement = "requests"
+)
+
+var (
+	ErrLogFormatMismatch = errors.New("line does not match log format")
+
+	logVariable = regexp.MustCompile(`\$[a-zA-Z0-9_]+`)
+)
+
+// accessLogParser turns lines written by an nginx log_format into the
+// points produced by the lua emitter.
+type accessLogParser struct {
+	re          *regexp.Regexp
+	vars        []string
+	measurement string
+	hostname    string
+}
+
+func newAccessLogParser(format, measurement string) (*accessLogParser, error) {
+	if format == "" {
+		format = DefaultLogFormat
+	}
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+
+	var (
+		pattern strings.Builder
+		vars    []string
+		last    int
+	)
+	pattern.WriteString("^")
+	locs := logVariable.FindAllStringIndex(format, -1)
+	for i, loc := range locs {
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		vars = append(vars, format[loc[0]+1:loc[1]])
+
+		// A variable ends at the first character of the literal following it.
+		switch {
+		case loc[1] == len(format):
+			pattern.WriteString("(.*)")
+		case i+1 < len(locs) && locs[i+1][0] == loc[1]:
+			return nil, fmt.Errorf("log format variables $%s and %s are not separated", vars[len(vars)-1], format[locs[i+1][0]:locs[i+1][1]])
+		default:
+			pattern.WriteString("([^" + regexp.QuoteMeta(format[loc[1]:loc[1]+1]) + "]*)")
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+	pattern.WriteString("$")
+
+	if len(vars) == 0 {
+		return nil, errors.New("log format has no variables")
+	}
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	return &accessLogParser{
+		re:          re,
+		vars:        vars,
+		measurement: measurement,
+		hostname:    hostname,
+	}, nil
+}
+
+// Parse returns the value of every variable of the log format.
+func (p *accessLogParser) Parse(line string) (map[string]string, error) {
+	m := p.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, ErrLogFormatMismatch
+	}
+
+	values := make(map[string]string, len(p.vars))
+	for i, name := range p.vars {
+		values[name] = m[i+1]
+	}
+	return values, nil
+}
+
+// Point parses line and builds a point tagged with host, server_name, path,
+// method, upstream and status_code holding response_time and response_size.
+func (p *accessLogParser) Point(line string) (models.Point, error) {
+	values, err := p.Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	return accessLogPoint(p.measurement, p.hostname, values)
+}
+
+// accessLogPoint builds a point out of nginx variables, "-" marks a variable
+// nginx had no value for.
+func accessLogPoint(measurement, hostname string, values map[string]string) (models.Point, error) {
+	get := func(names ...string) string {
+		for _, name := range names {
+			if v := values[name]; v != "" && v != "-" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	tags := make(map[string]string)
+	setTag := func(key, value string) {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+
+	host := get("hostname")
+	if host == "" {
+		host = hostname
+	}
+	setTag("host", host)
+	setTag("server_name", get("server_name", "host", "http_host"))
+	setTag("status_code", get("status"))
+	setTag("upstream", get("upstream_addr"))
+
+	method, path := get("request_method"), get("uri", "request_uri")
+	if request := get("request"); request != "" {
+		// $request is "GET /path?query HTTP/1.1"
+		parts := strings.Fields(request)
+		if len(parts) >= 2 {
+			if method == "" {
+				method = parts[0]
+			}
+			if path == "" {
+				path = parts[1]
+			}
+		}
+	}
+	setTag("method", method)
+	setTag("path", path)
+
+	fields := make(map[string]interface{})
+	if v := get("request_time"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request_time %q: %s", v, err)
+		}
+		fields["response_time"] = f
+	}
+	if v := get("body_bytes_sent", "bytes_sent"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response size %q: %s", v, err)
+		}
+		fields["response_size"] = n
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("log format has neither $request_time nor $body_bytes_sent")
+	}
+
+	t, err := accessLogTime(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.NewPoint(measurement, models.NewTags(tags), fields, t)
+}
+
+// accessLogTime returns the time of the request, or now if the log format
+// has no time variable.
+func accessLogTime(values map[string]string) (time.Time, error) {
+	if v := values["time_local"]; v != "" {
+		return time.Parse("02/Jan/2006:15:04:05 -0700", v)
+	}
+	if v := values["time_iso8601"]; v != "" {
+		return time.Parse(time.RFC3339, v)
+	}
+	if v := values["msec"]; v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f*1e9)).UTC(), nil
+	}
+	return time.Now().UTC(), nil
+}
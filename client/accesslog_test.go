@@ -0,0 +1,66 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessLogParser_Point(t *testing.T) {
+	format := `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time $server_name $hostname`
+	line := `10.0.0.1 - - [08/Dec/2016:13:37:23 +0800] "GET /ping?x=1 HTTP/1.1" 503 227 "-" "curl/7.47.0" 0.001 restapi.ele.me qcr-web-proxy-66`
+
+	p, err := newAccessLogParser(format, "")
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err)
+	}
+	pt, err := p.Point(line)
+	if err != nil {
+		t.Fatalf("failed to parse line: %s", err)
+	}
+
+	if pt.Name() != DefaultMeasurement {
+		t.Errorf("Expected measurement %s but found %s", DefaultMeasurement, pt.Name())
+	}
+	tags := pt.Tags().Map()
+	for k, v := range map[string]string{
+		"host":        "qcr-web-proxy-66",
+		"server_name": "restapi.ele.me",
+		"path":        "/ping?x=1",
+		"method":      "GET",
+		"status_code": "503",
+	} {
+		if tags[k] != v {
+			t.Errorf("Expected tag %s=%s but found %q", k, v, tags[k])
+		}
+	}
+	fields := pt.Fields()
+	if fields["response_time"] != 0.001 {
+		t.Errorf("Expected response_time %f but found %v", 0.001, fields["response_time"])
+	}
+	if fields["response_size"] != int64(227) {
+		t.Errorf("Expected response_size %d but found %v", 227, fields["response_size"])
+	}
+	if exp := time.Date(2016, 12, 8, 5, 37, 23, 0, time.UTC); !pt.Time().Equal(exp) {
+		t.Errorf("Expected time %s but found %s", exp, pt.Time())
+	}
+}
+
+func TestAccessLogParser_Errors(t *testing.T) {
+	if _, err := newAccessLogParser("no variables", ""); err == nil {
+		t.Error("Expected a log format without variables to be rejected")
+	}
+	if _, err := newAccessLogParser("$status$request_time", ""); err == nil {
+		t.Error("Expected adjacent variables to be rejected")
+	}
+
+	p, err := newAccessLogParser("$status $request_time", "")
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err)
+	}
+	if _, err := p.Point("garbage"); err != ErrLogFormatMismatch {
+		t.Errorf("Expected %v but found %v", ErrLogFormatMismatch, err)
+	}
+	if _, err := p.Point("200 fast"); err == nil {
+		t.Error("Expected an invalid request_time to be rejected")
+	}
+}
@@ -1,55 +1,235 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"log"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/models"
 )
 
 var (
 	ErrClientAlreadyOpened = errors.New("Client is already Opened")
+	ErrClientClosed        = errors.New("Client is closed")
 )
 
+// udpQueueSize is the number of datagrams buffered between the readers and Read.
+const udpQueueSize = 1024
+
+// Statistics keeps the counters of a Client.
+type Statistics struct {
+	DatagramsReceived  int64
+	BytesReceived      int64
+	DatagramsTruncated int64
+	ReadErrors         int64
+}
+
+// Client reads datagrams from one or more udp sockets sharing the same
+// address through SO_REUSEPORT.
 type Client struct {
-	hostname string
-	bindAddr string
-	ln       *net.UDPConn
+	mu sync.Mutex
+
+	hostname   string
+	bindAddr   string
+	bufferSize int
+	readBuffer int
+	readers    int
+
+	conns   []*net.UDPConn
+	packets chan packet
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	pool  sync.Pool
+	stats Statistics
+
+	Logger *log.Logger
 }
 
-func NewClient(config *Config) *Client {
-	c := &Client{bindAddr: config.BindAddress}
+func NewClient(config *InputConfig) *Client {
+	c := &Client{
+		bindAddr:   config.BindAddress,
+		bufferSize: config.BufferSize,
+		readBuffer: config.ReadBuffer,
+		readers:    config.Readers,
+		packets:    make(chan packet, udpQueueSize),
+		Logger:     log.New(os.Stderr, "[udp] ", log.LstdFlags),
+	}
+	if c.bufferSize == 0 {
+		c.bufferSize = DefaultUDPBufferSize
+	}
+	if c.readers == 0 {
+		c.readers = 1
+	}
+	c.pool.New = func() interface{} {
+		buf := make([]byte, c.bufferSize)
+		return &buf
+	}
 	return c
 }
 
 func (c *Client) Open() error {
-	ip := c.hostname + c.bindAddr
-	serverAddr, err := net.ResolveUDPAddr("udp", ip)
-	if err != nil {
-		return err
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conns != nil {
+		return ErrClientAlreadyOpened
 	}
 
-	serverConn, err := net.ListenUDP("udp", serverAddr)
-	if err != nil {
-		return err
+	addr := c.hostname + c.bindAddr
+	for i := 0; i < c.readers; i++ {
+		conn, err := c.listen(addr)
+		if err != nil {
+			for _, conn := range c.conns {
+				conn.Close()
+			}
+			c.conns = nil
+			return err
+		}
+
+		if c.readBuffer > 0 {
+			if err := conn.SetReadBuffer(c.readBuffer); err != nil {
+				c.Logger.Printf("failed to set read buffer to %d: %s", c.readBuffer, err)
+			}
+		}
+
+		// Every following socket must share the port of the first one, which
+		// matters when the bind address asks for any free port.
+		addr = conn.LocalAddr().String()
+		c.conns = append(c.conns, conn)
+	}
+
+	c.closing = make(chan struct{})
+	for _, conn := range c.conns {
+		c.wg.Add(1)
+		go c.readLoop(conn)
 	}
-	c.ln = serverConn
 
 	return nil
 }
 
+func (c *Client) listen(addr string) (*net.UDPConn, error) {
+	if c.readers == 1 {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return net.ListenUDP("udp", udpAddr)
+	}
+
+	lc := net.ListenConfig{Control: reusePort}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// Addr returns the address the client listens on, nil if it is not opened.
+func (c *Client) Addr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.conns) == 0 {
+		return nil
+	}
+	return c.conns[0].LocalAddr()
+}
+
 func (c *Client) Close() error {
-	if c.ln != nil {
-		return c.ln.Close()
+	c.mu.Lock()
+	if c.conns == nil {
+		c.mu.Unlock()
+		return nil
 	}
-	return nil
+
+	close(c.closing)
+	var err error
+	for _, conn := range c.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	c.conns = nil
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	return err
 }
 
+// Read blocks until a datagram is received by any of the sockets.
 func (c *Client) Read() ([]byte, error) {
-	buf := make([]byte, 1024)
-	n, _, err := c.ln.ReadFromUDP(buf)
-	if err != nil {
-		return nil, err
+	buf, _, err := c.ReadFrom()
+	return buf, err
+}
+
+// ReadFrom is Read returning the address the datagram is received from.
+func (c *Client) ReadFrom() ([]byte, net.Addr, error) {
+	select {
+	case p := <-c.packets:
+		return p.buf, p.addr, nil
+	case <-c.closing:
+		return nil, nil, ErrClientClosed
 	}
+}
+
+func (c *Client) readLoop(conn *net.UDPConn) {
+	defer c.wg.Done()
+
+	for {
+		bp := c.pool.Get().(*[]byte)
+		buf := *bp
+		n, _, flags, addr, err := conn.ReadMsgUDP(buf, nil)
+		if err != nil {
+			c.pool.Put(bp)
+			select {
+			case <-c.closing:
+				return
+			default:
+			}
+			atomic.AddInt64(&c.stats.ReadErrors, 1)
+			c.Logger.Printf("failed to read datagram: %s", err)
+			continue
+		}
+
+		atomic.AddInt64(&c.stats.DatagramsReceived, 1)
+		atomic.AddInt64(&c.stats.BytesReceived, int64(n))
+
+		// A datagram larger than the buffer is cut by the kernel, parsing
+		// the remainder would only produce garbage.
+		if flags&msgTrunc != 0 || (msgTrunc == 0 && n == len(buf)) {
+			c.pool.Put(bp)
+			atomic.AddInt64(&c.stats.DatagramsTruncated, 1)
+			continue
+		}
+
+		// the queue holds right-sized copies, small datagrams would
+		// otherwise pin a whole buffer each
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		c.pool.Put(bp)
+
+		select {
+		case c.packets <- packet{buf: data, addr: addr}:
+		case <-c.closing:
+			return
+		}
+	}
+}
 
-	//convert bytes array to string
-	return buf[:n], nil
+// Statistics returns the counters of the client.
+func (c *Client) Statistics(tags map[string]string) []models.Statistic {
+	return []models.Statistic{{
+		Name: "esm_filter_udp",
+		Tags: models.StatisticTags{"bind": c.bindAddr}.Merge(tags),
+		Values: map[string]interface{}{
+			"datagramsReceived":  atomic.LoadInt64(&c.stats.DatagramsReceived),
+			"bytesReceived":      atomic.LoadInt64(&c.stats.BytesReceived),
+			"datagramsTruncated": atomic.LoadInt64(&c.stats.DatagramsTruncated),
+			"readErrors":         atomic.LoadInt64(&c.stats.ReadErrors),
+		},
+	}}
 }
@@ -2,45 +2,148 @@ package client
 
 import (
 	"net"
+	"strings"
 	"testing"
 )
 
 func TestClient_Read(t *testing.T) {
-	testPort := ":9090"
 	testMsg := "test"
 
-	go func() {
-		serverAddr, err := net.ResolveUDPAddr("udp", testPort)
-		conn, err := net.DialUDP("udp", nil, serverAddr)
-		if err != nil {
-			t.Errorf("failed to create udp connection", err)
-		}
-
-		for {
-			_, err = conn.Write([]byte(testMsg))
-			if err != nil {
-				t.Errorf("failed to write message via udp", err)
-			}
-
-		}
-	}()
-	clientAddr, err := net.ResolveUDPAddr("udp", testPort)
-	if err != nil {
-		t.Errorf("failed to create server address", err)
+	c := NewClient(&InputConfig{BindAddress: "127.0.0.1:0"})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open client: %s", err)
 	}
+	defer c.Close()
 
-	c := &Client{}
-	clientLn, err := net.ListenUDP("udp", clientAddr)
+	conn, err := net.Dial("udp", c.Addr().String())
 	if err != nil {
-		t.Errorf("failed to create listener", err)
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(testMsg)); err != nil {
+		t.Fatalf("failed to write message via udp: %s", err)
 	}
-	c.ln = clientLn
+
 	buf, err := c.Read()
 	if err != nil {
-		t.Errorf("failed to read", err)
+		t.Errorf("failed to read: %s", err)
 	}
 	str := string(buf)
 	if str != testMsg {
 		t.Errorf("Expected the message %s wth length %d but found %s with length %d", testMsg, len(testMsg), str, len(str))
 	}
 }
+
+func TestClient_ReadFrom(t *testing.T) {
+	c := NewClient(&InputConfig{BindAddress: "127.0.0.1:0"})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open client: %s", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("udp", c.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("test"))
+
+	_, addr, err := c.ReadFrom()
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	if addr == nil || addr.String() != conn.LocalAddr().String() {
+		t.Errorf("Expected the source address %s but found %v", conn.LocalAddr(), addr)
+	}
+}
+
+func TestClient_ReadTruncated(t *testing.T) {
+	c := NewClient(&InputConfig{BindAddress: "127.0.0.1:0", BufferSize: 16})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open client: %s", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("udp", c.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(strings.Repeat("x", 64)))
+	conn.Write([]byte("short"))
+
+	buf, err := c.Read()
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	if string(buf) != "short" {
+		t.Errorf("Expected the truncated datagram to be dropped but found %q", buf)
+	}
+
+	stats := c.Statistics(nil)[0]
+	if v := stats.Values["datagramsTruncated"].(int64); v != 1 {
+		t.Errorf("Expected %d truncated datagram but found %d", 1, v)
+	}
+}
+
+func TestClient_ReadReusePort(t *testing.T) {
+	c := NewClient(&InputConfig{BindAddress: "127.0.0.1:0", Readers: 4})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open client: %s", err)
+	}
+	defer c.Close()
+
+	if len(c.conns) != 4 {
+		t.Fatalf("Expected %d sockets but found %d", 4, len(c.conns))
+	}
+
+	conn, err := net.Dial("udp", c.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+
+	n := 16
+	for i := 0; i < n; i++ {
+		conn.Write([]byte("test"))
+	}
+	for i := 0; i < n; i++ {
+		if _, err := c.Read(); err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+	}
+}
+
+func TestClient_ReadCopy(t *testing.T) {
+	c := NewClient(&InputConfig{BindAddress: "127.0.0.1:0", BufferSize: 1024})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open client: %s", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("udp", c.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+
+	// datagrams are copied out of the reused buffers, sized to fit
+	var bufs [][]byte
+	payloads := []string{"requests response_time=0.1", "requests response_time=0.2"}
+	for _, payload := range payloads {
+		conn.Write([]byte(payload))
+		buf, err := c.Read()
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		if cap(buf) != len(payload) {
+			t.Errorf("Expected a buffer of %d bytes but found %d", len(payload), cap(buf))
+		}
+		bufs = append(bufs, buf)
+	}
+	for i, payload := range payloads {
+		if string(bufs[i]) != payload {
+			t.Errorf("Expected %q but found %q", payload, bufs[i])
+		}
+	}
+}
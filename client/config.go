@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"errors"
 	"github.com/BurntSushi/toml"
+	itoml "github.com/influxdata/influxdb/toml"
 )
 
 const (
@@ -17,10 +19,27 @@ const (
 	DefaultHostName = "localhost"
 	// DefaultBindAddress is the default address to bind to
 	DefaultBindAddress = ":8286"
+	// DefaultProtocol is the default protocol used to receive points
+	DefaultProtocol = "udp"
+	// DefaultIdleTimeout is the default time a tcp connection may stay idle
+	DefaultIdleTimeout = 5 * time.Minute
+	// DefaultMaxConnections is the default limit of concurrent tcp connections, 0 means unlimited
+	DefaultMaxConnections = 0
 
 	DefaultDownstream = "localhost:8086"
 
 	DefaultTicket = 10
+
+	// DefaultWindowing buckets points by the time they are received
+	DefaultWindowing = "arrival"
+	// DefaultTimestamp stamps aggregates with the start of their window
+	DefaultTimestamp = "start"
+	// DefaultAllowedLateness is how long an event-time window stays open
+	// after its end
+	DefaultAllowedLateness = 10 * time.Second
+	// DefaultAllowedSkew is how far ahead of the clock an event-time point
+	// may be stamped
+	DefaultAllowedSkew = time.Minute
 )
 
 type Config struct {
@@ -28,7 +47,305 @@ type Config struct {
 	BindAddress string `toml:"bind-address"`
 	Downstream  string `toml:"downstream"`
 
+	// Protocol is one of "udp", "tcp" or "http"
+	Protocol       string         `toml:"protocol"`
+	IdleTimeout    itoml.Duration `toml:"idle-timeout"`
+	MaxConnections int            `toml:"max-connections"`
+
 	Ticket time.Duration `toml:"expired-time"`
+
+	// Windowing is "arrival" to bucket points by the time they are received,
+	// or "event-time" to bucket them by their own timestamps
+	Windowing string `toml:"windowing"`
+	// AllowedLateness is how long an event-time window waits for late
+	// points after its end, the points of closed windows are dropped
+	AllowedLateness itoml.Duration `toml:"allowed-lateness"`
+	// AllowedSkew is how far ahead of the clock an event-time point may be
+	// stamped, the points of clients with a clock further ahead are dropped
+	// rather than kept in a window until the clock reaches it
+	AllowedSkew itoml.Duration `toml:"allowed-skew"`
+
+	// Windows are the sizes aggregates are written for, each tagged with
+	// its size as "window". The smallest one replaces expired-time and the
+	// others are merged from it, so they must be multiples of it
+	Windows []WindowConfig `toml:"windows"`
+	// Timestamp is "start" or "end", the time of the window aggregates are
+	// stamped with. Windows are aligned to multiples of their size
+	Timestamp string `toml:"timestamp"`
+
+	// Inputs replaces the single input above when at least one is declared
+	Inputs []InputConfig `toml:"inputs"`
+
+	// Measurements sets the tags each measurement is grouped by, others
+	// are grouped by DefaultGroupTags
+	Measurements []MeasurementConfig `toml:"measurements"`
+
+	// StripQueryString removes the query string of the path tag
+	StripQueryString bool `toml:"strip-query-string"`
+	// PathRules rewrite the path tag before points are grouped, the first
+	// matching rule applies
+	PathRules []PathRuleConfig `toml:"path-rules"`
+	// PathLearning replaces the path segments holding ids with placeholders
+	// for the paths no rule matched
+	PathLearning PathLearningConfig `toml:"path-learning"`
+
+	// FailureRules decide which requests count as failures, the first rule
+	// matching the server_name and path of a request applies. Requests no
+	// rule matches fail if their status code is DefaultFailureCodes
+	FailureRules []FailureRuleConfig `toml:"failure-rules"`
+
+	// Percentiles of response_time written with every aggregate, such as
+	// 99.9 written as the field "p999"
+	Percentiles []float64 `toml:"percentiles"`
+	// Histogram writes cumulative response_time buckets with every aggregate
+	Histogram HistogramConfig `toml:"histogram"`
+	// Apdex sets the thresholds of the Apdex score of the requests of a
+	// server_name or path, the first matching one applies
+	Apdex []ApdexConfig `toml:"apdex"`
+	// FieldAggregations aggregate other numeric fields of the points
+	FieldAggregations []FieldAggregationConfig `toml:"field-aggregations"`
+	// StatusCodes sets how requests are counted by status code
+	StatusCodes StatusCodesConfig `toml:"status-codes"`
+
+	// DeadLetter keeps the payloads which could not be parsed
+	DeadLetter DeadLetterConfig `toml:"dead-letter"`
+
+	// MapReduce bounds the goroutines parsing the points of a window
+	MapReduce MapReduceConfig `toml:"mapreduce"`
+
+	// Statistics writes the counters of the inputs and of the pipeline
+	// after every window, nothing is written unless it is enabled
+	Statistics StatisticsConfig `toml:"statistics"`
+}
+
+// StatisticsConfig sets where the internal counters are written. An influx
+// udp listener writes into a single database, so the counters are kept out
+// of the SLA database by a downstream of their own.
+type StatisticsConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Downstream is the udp listener of the counters, the downstream of the
+	// aggregates if empty
+	Downstream string `toml:"downstream"`
+	// Prefix is prepended to the measurement of every counter
+	Prefix string `toml:"prefix"`
+}
+
+// MapReduceConfig sets the workers parsing points and what happens to the
+// points which do not fit in their queue, "block" to wait for a worker,
+// "drop-newest" to drop them or "drop-oldest" to drop the oldest queued
+// point. Zero values use the defaults of the mapreduce package.
+type MapReduceConfig struct {
+	Workers    int    `toml:"workers"`
+	QueueDepth int    `toml:"queue-depth"`
+	Overflow   string `toml:"overflow"`
+	// Ordered reduces the points in the order they are received rather
+	// than as soon as they are parsed
+	Ordered bool `toml:"ordered"`
+}
+
+const (
+	// DefaultDeadLetterMaxSize is the size a dead-letter file is rotated at
+	DefaultDeadLetterMaxSize = 10 * 1024 * 1024
+	// DefaultDeadLetterMaxBackups is the number of rotated dead-letter files kept
+	DefaultDeadLetterMaxBackups = 3
+)
+
+// DeadLetterConfig sets where the payloads which could not be parsed are
+// written, along with the address they came from and the error.
+type DeadLetterConfig struct {
+	// Path is the dead-letter file, nothing is written if it is empty
+	Path       string `toml:"path"`
+	MaxSize    int64  `toml:"max-size"`
+	MaxBackups int    `toml:"max-backups"`
+}
+
+// DefaultPercentiles are the percentiles of response_time written unless
+// configured.
+var DefaultPercentiles = []float64{50, 90, 99, 99.9}
+
+// DefaultHistogramBuckets are the upper bounds of the response_time buckets
+// in seconds unless configured, the ones of Prometheus client libraries.
+var DefaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramConfig writes the requests faster than every bucket bound as
+// the fields "le_<bound>", along with "le_inf" counting every request with
+// a response_time. Counters and requests without one are left out, so
+// le_inf may be lower than totalRequestTimes.
+type HistogramConfig struct {
+	Enabled bool      `toml:"enabled"`
+	Buckets []float64 `toml:"buckets"`
+}
+
+// ApdexConfig sets the response times of satisfied and tolerated requests
+// of a server_name, or of the paths matching Path. Slower and failed
+// requests frustrate users.
+type ApdexConfig struct {
+	// ServerName is the server_name the thresholds apply to, any if empty
+	ServerName string `toml:"server-name"`
+	// Path is a regular expression matched against the path as received,
+	// any path if empty
+	Path      string         `toml:"path"`
+	Satisfied itoml.Duration `toml:"satisfied"`
+	// Tolerating defaults to four times Satisfied
+	Tolerating itoml.Duration `toml:"tolerating"`
+}
+
+// FieldAggregationConfig aggregates a numeric field, every aggregation is
+// written as the field "<field>_<aggregation>", such as "response_size_sum".
+type FieldAggregationConfig struct {
+	Field string `toml:"field"`
+	// Aggregations are "sum", "count", "min", "max", "mean" or a percentile
+	// such as "p99" or "p99.9", the values of percentiles must not be negative
+	Aggregations []string `toml:"aggregations"`
+}
+
+// ParseAggregation returns the percentile of a percentile aggregation such
+// as "p99.9", 0 for the other aggregations.
+func ParseAggregation(s string) (float64, error) {
+	switch s {
+	case "sum", "count", "min", "max", "mean":
+		return 0, nil
+	}
+	if strings.HasPrefix(s, "p") {
+		if p, err := strconv.ParseFloat(s[1:], 64); err == nil && p > 0 && p < 100 {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown aggregation %q", s)
+}
+
+// StatusCodesConfig sets the fields requests are counted in by status code.
+type StatusCodesConfig struct {
+	// Mode is "exact" to count every status code in its own field such as
+	// "503", or "class" to count them in the fields "status_1xx" to
+	// "status_5xx" and "status_other"
+	Mode string `toml:"mode"`
+	// Keep are the status codes also counted in their own field in "class"
+	// mode
+	Keep []int `toml:"keep"`
+}
+
+// DefaultFailureCodes are the status codes counted as failures unless a
+// failure rule applies.
+var DefaultFailureCodes = []string{"400-999"}
+
+// FailureRuleConfig classifies the requests of a server_name, or of the
+// paths matching Path, as failures.
+type FailureRuleConfig struct {
+	// ServerName is the server_name the rule applies to, any if empty
+	ServerName string `toml:"server-name"`
+	// Path is a regular expression matched against the path as received,
+	// any path if empty
+	Path string `toml:"path"`
+	// Codes are the status codes of failures, such as "503", "5xx" or
+	// "400-403"
+	Codes []string `toml:"codes"`
+	// LatencyThreshold fails the requests slower than it, 0 disables it
+	LatencyThreshold itoml.Duration `toml:"latency-threshold"`
+}
+
+// ParseStatusCodes parses a status code, a class such as "5xx" or a range
+// such as "400-403" into the lowest and highest code it holds.
+func ParseStatusCodes(s string) (int, int, error) {
+	if len(s) == 3 && s[0] >= '1' && s[0] <= '9' && strings.ToLower(s[1:]) == "xx" {
+		lo := int(s[0]-'0') * 100
+		return lo, lo + 99, nil
+	}
+
+	lo, hi := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status code %q", s)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status code %q", s)
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("invalid status code range %q", s)
+	}
+	return min, max, nil
+}
+
+// DefaultPathLearningThreshold is the number of distinct values a path
+// segment may take before it is replaced with a placeholder.
+const DefaultPathLearningThreshold = 100
+
+// DefaultPathLearningMaxNodes is the number of path segments learned across
+// every server_name.
+const DefaultPathLearningMaxNodes = 100000
+
+// PathLearningConfig learns the templates of the paths of every server_name.
+// A segment position is only templated once it has seen more than Threshold
+// distinct values, the first ones are written as they are, so a few high
+// cardinality series still leak while the templates are learned. Path rules
+// printed by "esm-filter templates" avoid it on the next start.
+type PathLearningConfig struct {
+	Enabled   bool `toml:"enabled"`
+	Threshold int  `toml:"threshold"`
+	// MaxNodes bounds the segments learned, the rest of a path which would
+	// need more is replaced with "*"
+	MaxNodes int `toml:"max-nodes"`
+	// TemplatesFile is where the learned templates are saved after every
+	// window, "esm-filter templates" turns them into path rules
+	TemplatesFile string `toml:"templates-file"`
+}
+
+// WindowConfig is the size of windows. Every window is written into the
+// default retention policy of the udp listener, where windows are told apart
+// by their "window" tag.
+type WindowConfig struct {
+	Duration itoml.Duration `toml:"duration"`
+}
+
+// BaseWindow returns the size of the windows points are reduced in, the
+// smallest of Windows or expired-time.
+func (c *Config) BaseWindow() time.Duration {
+	if len(c.Windows) == 0 {
+		return c.Ticket * time.Second
+	}
+	base := time.Duration(c.Windows[0].Duration)
+	for _, w := range c.Windows[1:] {
+		if d := time.Duration(w.Duration); d < base {
+			base = d
+		}
+	}
+	return base
+}
+
+// PathRuleConfig rewrites the paths matching Pattern into Replacement, which
+// may refer to submatches as $1.
+type PathRuleConfig struct {
+	Pattern     string `toml:"pattern"`
+	Replacement string `toml:"replacement"`
+}
+
+// DefaultGroupTags are the tags a measurement is grouped by unless configured.
+var DefaultGroupTags = []string{"host", "server_name", "path"}
+
+// MeasurementConfig sets how the points of a measurement are aggregated.
+type MeasurementConfig struct {
+	Name string `toml:"name"`
+	// Tags are the tags points are grouped by, they are the only tags of the
+	// aggregates besides the name of the input
+	Tags []string `toml:"tags"`
+}
+
+// InputConfigs returns the declared inputs, or a single unnamed input built
+// from BindAddress and Protocol if none is declared.
+func (c *Config) InputConfigs() []InputConfig {
+	if len(c.Inputs) > 0 {
+		return c.Inputs
+	}
+	return []InputConfig{{
+		Protocol:       c.Protocol,
+		BindAddress:    c.BindAddress,
+		IdleTimeout:    c.IdleTimeout,
+		MaxConnections: c.MaxConnections,
+	}}
 }
 
 func (c *Config) ApplyEnvOverrides() error {
@@ -147,10 +464,6 @@ func (c *Config) applyEnvOverrides(prefix string, spec reflect.Value) error {
 }
 
 func (c *Config) Validate() error {
-	if c.BindAddress == "" {
-		return errors.New("BindAddress must be specified")
-	}
-
 	if c.HostName == "" {
 		return errors.New("HostName must be specified")
 	}
@@ -159,10 +472,160 @@ func (c *Config) Validate() error {
 		return errors.New("Downstream must be specified")
 	}
 
-	if c.Ticket == 0 {
+	if c.Ticket == 0 && len(c.Windows) == 0 {
 		return errors.New("Ticket must be specified")
 	}
 
+	switch c.Timestamp {
+	case "", "start", "end":
+	default:
+		return fmt.Errorf("unknown timestamp %q", c.Timestamp)
+	}
+
+	for _, w := range c.Windows {
+		if w.Duration <= 0 {
+			return errors.New("window duration must be positive")
+		}
+	}
+	windows := make(map[itoml.Duration]bool)
+	for _, w := range c.Windows {
+		if time.Duration(w.Duration)%c.BaseWindow() != 0 {
+			return fmt.Errorf("window %s is not a multiple of %s", time.Duration(w.Duration), c.BaseWindow())
+		}
+		if windows[w.Duration] {
+			return fmt.Errorf("window %s is declared more than once", time.Duration(w.Duration))
+		}
+		windows[w.Duration] = true
+	}
+
+	switch c.Windowing {
+	case "", "arrival", "event-time":
+	default:
+		return fmt.Errorf("unknown windowing %q", c.Windowing)
+	}
+	if c.AllowedLateness < 0 {
+		return errors.New("allowed lateness must not be negative")
+	}
+	if c.AllowedSkew < 0 {
+		return errors.New("allowed skew must not be negative")
+	}
+
+	names := make(map[string]bool)
+	for _, input := range c.InputConfigs() {
+		if err := input.Validate(); err != nil {
+			if input.Name == "" {
+				return err
+			}
+			return fmt.Errorf("input %s: %s", input.Name, err)
+		}
+
+		if len(c.Inputs) == 0 {
+			continue
+		}
+		if input.Name == "" {
+			return errors.New("Name must be specified for every input")
+		}
+		if names[input.Name] {
+			return fmt.Errorf("input %s is declared more than once", input.Name)
+		}
+		names[input.Name] = true
+	}
+
+	measurements := make(map[string]bool)
+	for _, m := range c.Measurements {
+		if m.Name == "" {
+			return errors.New("Name must be specified for every measurement")
+		}
+		if measurements[m.Name] {
+			return fmt.Errorf("measurement %s is declared more than once", m.Name)
+		}
+		measurements[m.Name] = true
+
+		for _, tag := range m.Tags {
+			if tag == "" || tag == "input" || tag == "window" {
+				return fmt.Errorf("measurement %s: invalid group tag %q", m.Name, tag)
+			}
+		}
+	}
+
+	for _, r := range c.PathRules {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("path rule %q: %s", r.Pattern, err)
+		}
+	}
+
+	for _, r := range c.FailureRules {
+		if _, err := regexp.Compile(r.Path); err != nil {
+			return fmt.Errorf("failure rule %q: %s", r.Path, err)
+		}
+		for _, codes := range r.Codes {
+			if _, _, err := ParseStatusCodes(codes); err != nil {
+				return fmt.Errorf("failure rule: %s", err)
+			}
+		}
+		if r.LatencyThreshold < 0 {
+			return errors.New("failure rule: latency threshold must not be negative")
+		}
+	}
+
+	for _, p := range c.Percentiles {
+		if p <= 0 || p >= 100 {
+			return fmt.Errorf("percentile %v is not between 0 and 100", p)
+		}
+	}
+
+	for i, b := range c.Histogram.Buckets {
+		if b <= 0 || (i > 0 && b <= c.Histogram.Buckets[i-1]) {
+			return errors.New("histogram buckets must be positive and increasing")
+		}
+	}
+
+	for _, a := range c.Apdex {
+		if _, err := regexp.Compile(a.Path); err != nil {
+			return fmt.Errorf("apdex %q: %s", a.Path, err)
+		}
+		if a.Satisfied <= 0 {
+			return errors.New("apdex: satisfied must be positive")
+		}
+		if a.Tolerating != 0 && a.Tolerating < a.Satisfied {
+			return errors.New("apdex: tolerating must not be less than satisfied")
+		}
+	}
+
+	for _, fa := range c.FieldAggregations {
+		if fa.Field == "" {
+			return errors.New("Field must be specified for every field aggregation")
+		}
+		for _, a := range fa.Aggregations {
+			if _, err := ParseAggregation(a); err != nil {
+				return fmt.Errorf("field aggregation %s: %s", fa.Field, err)
+			}
+		}
+	}
+
+	switch c.StatusCodes.Mode {
+	case "", "exact", "class":
+	default:
+		return fmt.Errorf("unknown status code mode %q", c.StatusCodes.Mode)
+	}
+
+	if c.DeadLetter.MaxSize < 0 || c.DeadLetter.MaxBackups < 0 {
+		return errors.New("dead letter max-size and max-backups must not be negative")
+	}
+
+	if c.MapReduce.Workers < 0 || c.MapReduce.QueueDepth < 0 {
+		return errors.New("mapreduce workers and queue-depth must not be negative")
+	}
+	switch c.MapReduce.Overflow {
+	case "", "block", "drop-newest", "drop-oldest":
+	default:
+		return fmt.Errorf("unknown mapreduce overflow %q", c.MapReduce.Overflow)
+	}
+
+	if c.PathLearning.Threshold < 0 || c.PathLearning.MaxNodes < 0 {
+		return errors.New("path learning threshold and max-nodes must not be negative")
+	}
+
 	return nil
 }
 func ParseConfig(path string) (*Config, error) {
@@ -183,5 +646,14 @@ func NewDemoConfig() *Config {
 		BindAddress: DefaultBindAddress,
 		Downstream:  DefaultDownstream,
 		Ticket:      DefaultTicket,
+		Windowing:   DefaultWindowing,
+		Timestamp:   DefaultTimestamp,
+
+		AllowedLateness: itoml.Duration(DefaultAllowedLateness),
+		AllowedSkew:     itoml.Duration(DefaultAllowedSkew),
+
+		Protocol:       DefaultProtocol,
+		IdleTimeout:    itoml.Duration(DefaultIdleTimeout),
+		MaxConnections: DefaultMaxConnections,
 	}
 }
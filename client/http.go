@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// HTTPClient mimics the InfluxDB http write api, so anything able to write
+// to InfluxDB can write to esm-filter instead.
+type HTTPClient struct {
+	mu sync.Mutex
+
+	bindAddr    string
+	maxBodySize int64
+
+	ln      net.Listener
+	server  *http.Server
+	batches chan []byte
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	Logger *log.Logger
+}
+
+func NewHTTPClient(config *InputConfig) *HTTPClient {
+	return &HTTPClient{
+		bindAddr:    config.BindAddress,
+		maxBodySize: int64(config.BufferSize),
+		batches:     make(chan []byte),
+		Logger:      log.New(os.Stderr, "[http] ", log.LstdFlags),
+	}
+}
+
+func (c *HTTPClient) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ln != nil {
+		return ErrClientAlreadyOpened
+	}
+
+	ln, err := net.Listen("tcp", c.bindAddr)
+	if err != nil {
+		return err
+	}
+	c.ln = ln
+	c.closing = make(chan struct{})
+	c.server = &http.Server{Handler: c}
+
+	c.wg.Add(1)
+	go func(srv *http.Server) {
+		defer c.wg.Done()
+		srv.Serve(ln)
+	}(c.server)
+
+	return nil
+}
+
+// Addr returns the address the client listens on, nil if it is not opened.
+func (c *HTTPClient) Addr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ln == nil {
+		return nil
+	}
+	return c.ln.Addr()
+}
+
+func (c *HTTPClient) Close() error {
+	c.mu.Lock()
+	if c.ln == nil {
+		c.mu.Unlock()
+		return nil
+	}
+
+	close(c.closing)
+	err := c.server.Close()
+	c.ln = nil
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	return err
+}
+
+// Read blocks until a write request has been accepted. The returned payload
+// holds every point of the request in line protocol with nanosecond timestamps.
+func (c *HTTPClient) Read() ([]byte, error) {
+	select {
+	case buf := <-c.batches:
+		return buf, nil
+	case <-c.closing:
+		return nil, ErrClientClosed
+	}
+}
+
+// ServeHTTP handles the /write and /ping endpoints of the InfluxDB api.
+func (c *HTTPClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/write":
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		c.serveWrite(w, r)
+	case "/ping":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (c *HTTPClient) serveWrite(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("db") == "" {
+		httpError(w, http.StatusBadRequest, "database is required")
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	if c.maxBodySize > 0 {
+		body = io.LimitReader(body, c.maxBodySize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.maxBodySize > 0 && int64(len(data)) > c.maxBodySize {
+		httpError(w, http.StatusRequestEntityTooLarge, "request entity too large")
+		return
+	}
+
+	buf, err := normalizePoints(data, time.Now().UTC(), q.Get("precision"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(buf) > 0 {
+		select {
+		case c.batches <- buf:
+		case <-c.closing:
+			httpError(w, http.StatusServiceUnavailable, "server is closing")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// normalizePoints parses data line by line and rewrites every point with
+// nanosecond precision. The error names the first line which failed to parse.
+func normalizePoints(data []byte, now time.Time, precision string) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		points, err := models.ParsePointsWithPrecision(line, now, precision)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		for _, p := range points {
+			io.WriteString(&buf, p.String())
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", msg)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPClient_Write(t *testing.T) {
+	c := NewHTTPClient(&InputConfig{BindAddress: "127.0.0.1:0"})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open http client: %s", err)
+	}
+	defer c.Close()
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte("requests,host=a response_time=0.001 1481175443\nrequests,host=b response_time=0.002 1481175444\n"))
+	gz.Close()
+
+	req, err := http.NewRequest("POST", "http://"+c.Addr().String()+"/write?db=sla&precision=s", &body)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("failed to write: %s", err)
+		}
+		done <- resp
+	}()
+
+	buf, err := c.Read()
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	exp := "requests,host=a response_time=0.001 1481175443000000000\nrequests,host=b response_time=0.002 1481175444000000000\n"
+	if string(buf) != exp {
+		t.Errorf("Expected %q but found %q", exp, buf)
+	}
+
+	if resp := <-done; resp != nil && resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d but found %d", http.StatusNoContent, resp.StatusCode)
+	}
+}
+
+func TestHTTPClient_WriteErrors(t *testing.T) {
+	c := NewHTTPClient(&InputConfig{})
+	for _, tt := range []struct {
+		method string
+		url    string
+		body   string
+		code   int
+		err    string
+	}{
+		{"POST", "/write", "requests response_time=1", http.StatusBadRequest, "database is required"},
+		{"POST", "/write?db=sla", "requests response_time=1\n\nrequests response_time=", http.StatusBadRequest, "line 3"},
+		{"GET", "/write?db=sla", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"GET", "/query", "", http.StatusNotFound, "not found"},
+	} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: Expected status %d but found %d", tt.method, tt.url, tt.code, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tt.err) {
+			t.Errorf("%s %s: Expected error containing %q but found %q", tt.method, tt.url, tt.err, w.Body.String())
+		}
+	}
+}
+
+func TestHTTPClient_Ping(t *testing.T) {
+	c := NewHTTPClient(&InputConfig{})
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d but found %d", http.StatusNoContent, w.Code)
+	}
+}
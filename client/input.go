@@ -0,0 +1,169 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	itoml "github.com/influxdata/influxdb/toml"
+)
+
+const (
+	// DefaultUDPBufferSize is the default size of a udp datagram, large enough for any of them
+	DefaultUDPBufferSize = 64 * 1024
+	// DefaultTCPBufferSize is the default size of a line read from a tcp connection
+	DefaultTCPBufferSize = 64 * 1024
+)
+
+// Input is a source of raw line protocol payloads.
+type Input interface {
+	Open() error
+	Close() error
+	Read() ([]byte, error)
+}
+
+// SourceReader is implemented by inputs which know the address every
+// payload is received from.
+type SourceReader interface {
+	ReadFrom() ([]byte, net.Addr, error)
+}
+
+// packet is a payload along with the address it is received from.
+type packet struct {
+	buf  []byte
+	addr net.Addr
+}
+
+// InputConfig describes a single named input.
+type InputConfig struct {
+	// Name is added as the "input" tag to every aggregate read from this input
+	Name string `toml:"name"`
+	// Protocol is one of "udp", "tcp", "http", "tail", "syslog" or "statsd"
+	Protocol    string `toml:"protocol"`
+	BindAddress string `toml:"bind-address"`
+	// BufferSize is the largest datagram, line or request body accepted, 0 means the protocol default
+	BufferSize int `toml:"buffer-size"`
+	// Format is the payload format of "udp" and "tcp" inputs, "line-protocol" or "json"
+	Format string `toml:"format"`
+	// JSON is the mapping of "json" payloads, NewJSONConfig sets the keys which are empty
+	JSON JSONConfig `toml:"json"`
+
+	// ReadBuffer sets SO_RCVBUF of udp sockets, 0 keeps the system default
+	ReadBuffer int `toml:"read-buffer"`
+	// Readers is the number of udp sockets sharing BindAddress through SO_REUSEPORT
+	Readers int `toml:"readers"`
+
+	// IdleTimeout closes tcp connections idle for longer, 0 means DefaultIdleTimeout
+	IdleTimeout    itoml.Duration `toml:"idle-timeout"`
+	MaxConnections int            `toml:"max-connections"`
+
+	// Files are the glob patterns of access logs followed by a "tail" input
+	Files []string `toml:"files"`
+	// LogFormat is the nginx log_format of "tail" and "syslog" inputs
+	LogFormat    string         `toml:"log-format"`
+	Measurement  string         `toml:"measurement"`
+	PositionFile string         `toml:"position-file"`
+	PollInterval itoml.Duration `toml:"poll-interval"`
+
+	// StatsD is the mapping of a "statsd" input, NewStatsDConfig is used if it is empty
+	StatsD StatsDConfig `toml:"statsd"`
+}
+
+// JSONConfig maps the keys of json access log records to a point.
+type JSONConfig struct {
+	// Measurement is used unless MeasurementKey is set and found in the record
+	Measurement    string `toml:"measurement"`
+	MeasurementKey string `toml:"measurement-key"`
+	// Tags maps json keys to the tags of the point, such as "request_uri" to "path"
+	Tags            map[string]string `toml:"tags"`
+	StatusCodeKey   string            `toml:"status-code-key"`
+	ResponseTimeKey string            `toml:"response-time-key"`
+	// TimeKey holds either RFC 3339 time or seconds since epoch, the time of arrival is used if a record lacks it
+	TimeKey string `toml:"time-key"`
+}
+
+// NewJSONConfig returns the mapping of the nginx variable names.
+func NewJSONConfig() JSONConfig {
+	return JSONConfig{
+		Measurement: DefaultMeasurement,
+		Tags: map[string]string{
+			"hostname":    "host",
+			"server_name": "server_name",
+			"uri":         "path",
+		},
+		StatusCodeKey:   "status",
+		ResponseTimeKey: "request_time",
+		TimeKey:         "msec",
+	}
+}
+
+func (c *InputConfig) Validate() error {
+	switch c.Format {
+	case "", "line-protocol":
+	case "json":
+		switch c.Protocol {
+		case "", "udp", "tcp":
+		default:
+			return fmt.Errorf("format json is not supported by protocol %s", c.Protocol)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+
+	switch c.Protocol {
+	case "", "udp", "tcp", "http", "statsd":
+		if c.BindAddress == "" {
+			return errors.New("BindAddress must be specified")
+		}
+	case "tail", "syslog":
+		if c.Protocol == "tail" && len(c.Files) == 0 {
+			return errors.New("Files must be specified")
+		}
+		if c.Protocol == "syslog" && c.BindAddress == "" {
+			return errors.New("BindAddress must be specified")
+		}
+		if _, err := newAccessLogParser(c.LogFormat, c.Measurement); err != nil {
+			return fmt.Errorf("invalid log format: %s", err)
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q", c.Protocol)
+	}
+
+	if c.BufferSize < 0 {
+		return errors.New("BufferSize must not be negative")
+	}
+
+	if c.ReadBuffer < 0 {
+		return errors.New("ReadBuffer must not be negative")
+	}
+
+	if c.Readers < 0 {
+		return errors.New("Readers must not be negative")
+	}
+
+	if c.MaxConnections < 0 {
+		return errors.New("MaxConnections must not be negative")
+	}
+
+	return nil
+}
+
+// NewInput returns the Input matching the protocol in config.
+func NewInput(config *InputConfig) (Input, error) {
+	switch config.Protocol {
+	case "", "udp":
+		return NewClient(config), nil
+	case "tcp":
+		return NewTCPClient(config), nil
+	case "http":
+		return NewHTTPClient(config), nil
+	case "tail":
+		return NewTailClient(config)
+	case "syslog":
+		return NewSyslogClient(config)
+	case "statsd":
+		return NewStatsDClient(config)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", config.Protocol)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package client
+
+import (
+	"errors"
+	"syscall"
+)
+
+// msgTrunc is unknown on this platform, a datagram filling the whole buffer
+// is considered truncated instead.
+const msgTrunc = 0
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform, set readers to 1")
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package client
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// msgTrunc is the flag set by recvmsg when a datagram did not fit the buffer.
+const msgTrunc = unix.MSG_TRUNC
+
+// reusePort lets several sockets bind the same udp address, the kernel then
+// balances the datagrams between them.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); e != nil {
+		return e
+	}
+	return err
+}
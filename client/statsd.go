@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// DefaultStatsDTemplate names the parts of "service.path.latency".
+const DefaultStatsDTemplate = "server_name.path*.field"
+
+// DefaultStatsDCounterMeasurement is the measurement of StatsD counters.
+const DefaultStatsDCounterMeasurement = "statsd_counters"
+
+// StatsDConfig maps StatsD and DogStatsD metrics to request points.
+type StatsDConfig struct {
+	Measurement string `toml:"measurement"`
+	// CounterMeasurement is the measurement of the counters, apart from the
+	// timers so that a service sending both is not counted twice
+	CounterMeasurement string `toml:"counter-measurement"`
+	// Template names the dot separated parts of a metric name with tags, the
+	// "field" part is dropped and a part ending with "*" takes every extra part.
+	// The path tag starts with "/" like the ones of nginx, so path rules
+	// apply to "svc.orders.latency" as "/orders"
+	Template string `toml:"template"`
+	// Tags maps DogStatsD tag keys to the tags of the point
+	Tags map[string]string `toml:"tags"`
+}
+
+// NewStatsDConfig returns the mapping used when none is configured.
+func NewStatsDConfig() StatsDConfig {
+	return StatsDConfig{
+		Measurement:        DefaultMeasurement,
+		CounterMeasurement: DefaultStatsDCounterMeasurement,
+		Template:           DefaultStatsDTemplate,
+		Tags: map[string]string{
+			"status":      "status_code",
+			"status_code": "status_code",
+			"host":        "host",
+			"method":      "method",
+		},
+	}
+}
+
+// StatsDClient receives StatsD packets over udp. Every timer becomes a
+// request holding its latency, every counter becomes as many requests
+// without latency in the counter measurement, other metric types are
+// dropped. The "count" field of the points is the number of requests they
+// stand for.
+type StatsDClient struct {
+	udp    *Client
+	config StatsDConfig
+
+	template []string
+
+	parseErrors int64
+	dropped     int64
+
+	Logger *log.Logger
+}
+
+func NewStatsDClient(config *InputConfig) (*StatsDClient, error) {
+	c := config.StatsD
+	if c.Template == "" && len(c.Tags) == 0 {
+		c = NewStatsDConfig()
+	}
+	if c.Measurement == "" {
+		c.Measurement = DefaultMeasurement
+	}
+	if c.CounterMeasurement == "" {
+		c.CounterMeasurement = DefaultStatsDCounterMeasurement
+	}
+	if c.Template == "" {
+		c.Template = DefaultStatsDTemplate
+	}
+
+	template := strings.Split(c.Template, ".")
+	greedy := 0
+	for _, part := range template {
+		if strings.HasSuffix(part, "*") {
+			greedy++
+		}
+	}
+	if greedy > 1 {
+		return nil, fmt.Errorf("template %q has more than one part ending with *", c.Template)
+	}
+
+	return &StatsDClient{
+		udp:      NewClient(config),
+		config:   c,
+		template: template,
+		Logger:   log.New(os.Stderr, "[statsd] ", log.LstdFlags),
+	}, nil
+}
+
+func (c *StatsDClient) Open() error  { return c.udp.Open() }
+func (c *StatsDClient) Close() error { return c.udp.Close() }
+
+// Read blocks until a packet holding at least one timer or counter is received.
+func (c *StatsDClient) Read() ([]byte, error) {
+	for {
+		buf, err := c.udp.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		now := time.Now().UTC()
+		for _, line := range bytes.Split(buf, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			p, err := c.point(string(line), now)
+			if err == errStatsDUnsupported {
+				atomic.AddInt64(&c.dropped, 1)
+				continue
+			} else if err != nil {
+				atomic.AddInt64(&c.parseErrors, 1)
+				c.Logger.Printf("failed to parse %q: %s", line, err)
+				continue
+			}
+			out.WriteString(p.String())
+			out.WriteByte('\n')
+		}
+
+		if out.Len() > 0 {
+			return out.Bytes(), nil
+		}
+	}
+}
+
+var errStatsDUnsupported = errors.New("unsupported metric type")
+
+// point translates "name:value|type|@rate|#key:value,key:value".
+func (c *StatsDClient) point(line string, now time.Time) (models.Point, error) {
+	i := strings.IndexByte(line, ':')
+	if i <= 0 {
+		return nil, errors.New("missing value")
+	}
+	name, parts := line[:i], strings.Split(line[i+1:], "|")
+	if len(parts) < 2 {
+		return nil, errors.New("missing metric type")
+	}
+
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %s", err)
+	}
+	// negative counters are valid StatsD but cannot be counted as requests
+	if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("invalid value %q", parts[0])
+	}
+
+	rate := 1.0
+	tags := make(map[string]string)
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			if rate, err = strconv.ParseFloat(part[1:], 64); err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", part[1:])
+			}
+		case strings.HasPrefix(part, "#"):
+			for _, kv := range strings.Split(part[1:], ",") {
+				j := strings.IndexByte(kv, ':')
+				if j <= 0 {
+					continue
+				}
+				if tag, ok := c.config.Tags[kv[:j]]; ok && kv[j+1:] != "" {
+					tags[tag] = kv[j+1:]
+				}
+			}
+		}
+	}
+
+	if err := c.applyTemplate(name, tags); err != nil {
+		return nil, err
+	}
+
+	measurement := c.config.Measurement
+	fields := make(map[string]interface{})
+	switch parts[1] {
+	case "ms":
+		fields["response_time"] = value / 1000
+		fields["count"] = 1 / rate
+	case "h", "d":
+		// DogStatsD histograms and distributions carry no unit, they are
+		// taken as seconds like response_time
+		fields["response_time"] = value
+		fields["count"] = 1 / rate
+	case "c":
+		measurement = c.config.CounterMeasurement
+		fields["count"] = value / rate
+	default:
+		return nil, errStatsDUnsupported
+	}
+
+	return models.NewPoint(measurement, models.NewTags(tags), fields, now)
+}
+
+// applyTemplate sets the tags named by the template, tags set by DogStatsD
+// take precedence.
+func (c *StatsDClient) applyTemplate(name string, tags map[string]string) error {
+	parts := strings.Split(name, ".")
+	extra := len(parts) - len(c.template)
+	if extra < 0 {
+		return fmt.Errorf("name has less parts than template %q", c.config.Template)
+	}
+
+	j := 0
+	for _, key := range c.template {
+		value := parts[j]
+		j++
+		if strings.HasSuffix(key, "*") {
+			key = key[:len(key)-1]
+			value = strings.Join(parts[j-1:j+extra], ".")
+			j += extra
+			extra = 0
+		}
+		if key == "field" || key == "" {
+			continue
+		}
+		if key == "path" {
+			value = "/" + value
+		}
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+	if extra > 0 {
+		return fmt.Errorf("name has more parts than template %q", c.config.Template)
+	}
+	return nil
+}
+
+// Statistics returns the counters of the underlying udp client along with
+// the number of metrics which could not be parsed or were dropped.
+func (c *StatsDClient) Statistics(tags map[string]string) []models.Statistic {
+	stats := c.udp.Statistics(tags)
+	stats[0].Name = "esm_filter_statsd"
+	stats[0].Values["parseErrors"] = atomic.LoadInt64(&c.parseErrors)
+	stats[0].Values["metricsDropped"] = atomic.LoadInt64(&c.dropped)
+	return stats
+}
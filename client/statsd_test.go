@@ -0,0 +1,74 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDClient_Point(t *testing.T) {
+	c, err := NewStatsDClient(&InputConfig{Protocol: "statsd"})
+	if err != nil {
+		t.Fatalf("failed to create statsd client: %s", err)
+	}
+
+	now := time.Unix(1481175443, 0).UTC()
+	for _, tt := range []struct {
+		line string
+		exp  string
+	}{
+		{"restapi.ping.latency:12|ms|#status:503", "requests,path=/ping,server_name=restapi,status_code=503 count=1,response_time=0.012 1481175443000000000"},
+		{"restapi.orders.create.latency:12|ms|@0.5", "requests,path=/orders.create,server_name=restapi count=2,response_time=0.012 1481175443000000000"},
+		{"restapi.ping.latency:0.25|h", "requests,path=/ping,server_name=restapi count=1,response_time=0.25 1481175443000000000"},
+		{"restapi.ping.requests:5|c|#status:200,server_name:other", "statsd_counters,path=/ping,server_name=restapi,status_code=200 count=5 1481175443000000000"},
+	} {
+		p, err := c.point(tt.line, now)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", tt.line, err)
+			continue
+		}
+		if p.String() != tt.exp {
+			t.Errorf("%s: Expected %s but found %s", tt.line, tt.exp, p.String())
+		}
+	}
+
+	for _, line := range []string{"restapi.ping.latency", "restapi.ping.latency:x|ms", "restapi.ping.latency:1", "ping:1|ms", "restapi.ping.latency:1|ms|@2", "restapi.ping.requests:-3|c", "restapi.ping.latency:NaN|ms", "restapi.ping.requests:+Inf|c"} {
+		if _, err := c.point(line, now); err == nil || err == errStatsDUnsupported {
+			t.Errorf("%s: Expected a parse error but found %v", line, err)
+		}
+	}
+	if _, err := c.point("restapi.ping.users:5|g", now); err != errStatsDUnsupported {
+		t.Errorf("Expected %v but found %v", errStatsDUnsupported, err)
+	}
+}
+
+func TestStatsDClient_Read(t *testing.T) {
+	c, err := NewStatsDClient(&InputConfig{Protocol: "statsd", BindAddress: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("failed to create statsd client: %s", err)
+	}
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open statsd client: %s", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("udp", c.udp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("restapi.ping.users:5|g"))
+	conn.Write([]byte("restapi.ping.latency:12|ms\nrestapi.ping.latency:14|ms\n"))
+
+	buf, err := c.Read()
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	if n := strings.Count(string(buf), "\n"); n != 2 {
+		t.Errorf("Expected %d points but found %d in %s", 2, n, buf)
+	}
+	if v := c.Statistics(nil)[0].Values["metricsDropped"].(int64); v != 1 {
+		t.Errorf("Expected %d dropped metric but found %d", 1, v)
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+var ErrInvalidSyslog = errors.New("invalid syslog message")
+
+// SyslogClient receives nginx access logs sent to syslog over udp, either
+// as RFC 5424 or RFC 3164 messages, and turns every line into a point.
+type SyslogClient struct {
+	udp    *Client
+	parser *accessLogParser
+
+	parseErrors int64
+
+	Logger *log.Logger
+}
+
+func NewSyslogClient(config *InputConfig) (*SyslogClient, error) {
+	parser, err := newAccessLogParser(config.LogFormat, config.Measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogClient{
+		udp:    NewClient(config),
+		parser: parser,
+		Logger: log.New(os.Stderr, "[syslog] ", log.LstdFlags),
+	}, nil
+}
+
+func (c *SyslogClient) Open() error  { return c.udp.Open() }
+func (c *SyslogClient) Close() error { return c.udp.Close() }
+
+// Read blocks until a message holding a valid access log line is received.
+func (c *SyslogClient) Read() ([]byte, error) {
+	for {
+		buf, err := c.udp.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := c.point(buf)
+		if err != nil {
+			atomic.AddInt64(&c.parseErrors, 1)
+			c.Logger.Printf("failed to parse message: %s", err)
+			continue
+		}
+		return []byte(p.String()), nil
+	}
+}
+
+func (c *SyslogClient) point(buf []byte) (models.Point, error) {
+	hostname, msg, err := parseSyslog(string(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := c.parser.Parse(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if hostname == "" {
+		hostname = c.parser.hostname
+	}
+	return accessLogPoint(c.parser.measurement, hostname, values)
+}
+
+// Statistics returns the counters of the underlying udp client along with
+// the number of messages which could not be parsed.
+func (c *SyslogClient) Statistics(tags map[string]string) []models.Statistic {
+	stats := c.udp.Statistics(tags)
+	stats[0].Name = "esm_filter_syslog"
+	stats[0].Values["parseErrors"] = atomic.LoadInt64(&c.parseErrors)
+	return stats
+}
+
+// parseSyslog strips the syslog header and returns the hostname of the
+// sender and the message.
+func parseSyslog(s string) (hostname, msg string, err error) {
+	s = strings.TrimRight(s, "\r\n\x00")
+
+	// Both formats start with "<PRI>".
+	if len(s) < 3 || s[0] != '<' {
+		return "", "", ErrInvalidSyslog
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 || !isDigits(s[1:end]) {
+		return "", "", ErrInvalidSyslog
+	}
+	s = s[end+1:]
+
+	// RFC 5424 follows with a version number and a space.
+	if i := strings.IndexByte(s, ' '); i > 0 && isDigits(s[:i]) {
+		return parseRFC5424(s[i+1:])
+	}
+	return parseRFC3164(s)
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG]".
+func parseRFC5424(s string) (hostname, msg string, err error) {
+	var fields [5]string
+	for i := range fields {
+		j := strings.IndexByte(s, ' ')
+		if j < 0 {
+			return "", "", ErrInvalidSyslog
+		}
+		fields[i], s = s[:j], s[j+1:]
+	}
+
+	// Skip the structured data, "-" or a sequence of "[...]" elements
+	// in which "]" may be escaped.
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else {
+		for strings.HasPrefix(s, "[") {
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '\\' {
+					i++
+				} else if s[i] == ']' {
+					break
+				}
+			}
+			if i >= len(s) {
+				return "", "", ErrInvalidSyslog
+			}
+			s = s[i+1:]
+		}
+	}
+	s = strings.TrimPrefix(s, " ")
+	s = strings.TrimPrefix(s, "\xef\xbb\xbf")
+
+	if hostname = fields[1]; hostname == "-" {
+		hostname = ""
+	}
+	return hostname, s, nil
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG: MSG".
+func parseRFC3164(s string) (hostname, msg string, err error) {
+	// The timestamp has a fixed length, days are padded with a space.
+	const stamp = len("Jan _2 15:04:05")
+	if len(s) < stamp+1 || s[stamp] != ' ' {
+		return "", "", ErrInvalidSyslog
+	}
+	s = s[stamp+1:]
+
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return "", "", ErrInvalidSyslog
+	}
+	hostname, s = s[:i], s[i+1:]
+
+	// The tag ends with ":" or with "[pid]:".
+	if i := strings.Index(s, ": "); i >= 0 && !strings.ContainsAny(s[:i], " ") {
+		s = s[i+2:]
+	}
+	return hostname, s, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseSyslog(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		hostname string
+		msg      string
+	}{
+		{"<190>Dec  8 13:37:23 qcr-web-proxy-66 nginx: 200 0.001", "qcr-web-proxy-66", "200 0.001"},
+		{"<190>Dec 18 13:37:23 qcr-web-proxy-66 nginx[42]: 200 0.001\n", "qcr-web-proxy-66", "200 0.001"},
+		{"<190>1 2016-12-08T13:37:23.003Z qcr-web-proxy-66 nginx - - - 200 0.001", "qcr-web-proxy-66", "200 0.001"},
+		{`<190>1 2016-12-08T13:37:23Z - nginx 42 ID7 [a@1 b="x\]y"][c@2 d="e"] 200 0.001`, "", "200 0.001"},
+	} {
+		hostname, msg, err := parseSyslog(tt.in)
+		if err != nil {
+			t.Errorf("%q: failed to parse: %s", tt.in, err)
+			continue
+		}
+		if hostname != tt.hostname || msg != tt.msg {
+			t.Errorf("%q: Expected (%q, %q) but found (%q, %q)", tt.in, tt.hostname, tt.msg, hostname, msg)
+		}
+	}
+
+	for _, in := range []string{"", "200 0.001", "<abc>Dec  8 13:37:23 host nginx: x", "<190>1 2016-12-08T13:37:23Z host"} {
+		if _, _, err := parseSyslog(in); err != ErrInvalidSyslog {
+			t.Errorf("%q: Expected %v but found %v", in, ErrInvalidSyslog, err)
+		}
+	}
+}
+
+func TestSyslogClient_Read(t *testing.T) {
+	c, err := NewSyslogClient(&InputConfig{
+		Protocol:    "syslog",
+		BindAddress: "127.0.0.1:0",
+		LogFormat:   "$server_name $status $request_time",
+	})
+	if err != nil {
+		t.Fatalf("failed to create syslog client: %s", err)
+	}
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open syslog client: %s", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("udp", c.udp.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create udp connection: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("not syslog"))
+	conn.Write([]byte("<190>Dec  8 13:37:23 qcr-web-proxy-66 nginx: restapi.ele.me 503 0.001"))
+
+	buf, err := c.Read()
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	for _, s := range []string{"host=qcr-web-proxy-66", "server_name=restapi.ele.me", "status_code=503", "response_time=0.001"} {
+		if !strings.Contains(string(buf), s) {
+			t.Errorf("Expected %s in %s", s, buf)
+		}
+	}
+
+	stats := c.Statistics(nil)[0]
+	if v := stats.Values["parseErrors"].(int64); v != 1 {
+		t.Errorf("Expected %d parse error but found %d", 1, v)
+	}
+}
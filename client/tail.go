@@ -0,0 +1,336 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is the default interval between two checks of tailed files.
+const DefaultPollInterval = time.Second
+
+// position is where reading of a file stopped, it is persisted so a restart
+// neither loses nor repeats lines.
+type position struct {
+	Offset int64  `json:"offset"`
+	FileID uint64 `json:"file_id"`
+}
+
+// tailedFile is a file currently followed.
+type tailedFile struct {
+	f       *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+// TailClient follows nginx access log files, surviving rotation by rename or
+// truncation, and turns every line into a point.
+type TailClient struct {
+	mu sync.Mutex
+
+	patterns     []string
+	positionPath string
+	pollInterval time.Duration
+	parser       *accessLogParser
+
+	files     map[string]*tailedFile
+	positions map[string]position
+
+	lines   chan []byte
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	Logger *log.Logger
+}
+
+func NewTailClient(config *InputConfig) (*TailClient, error) {
+	parser, err := newAccessLogParser(config.LogFormat, config.Measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TailClient{
+		patterns:     config.Files,
+		positionPath: config.PositionFile,
+		pollInterval: time.Duration(config.PollInterval),
+		parser:       parser,
+		files:        make(map[string]*tailedFile),
+		positions:    make(map[string]position),
+		lines:        make(chan []byte),
+		Logger:       log.New(os.Stderr, "[tail] ", log.LstdFlags),
+	}
+	if c.pollInterval == 0 {
+		c.pollInterval = DefaultPollInterval
+	}
+	return c, nil
+}
+
+func (c *TailClient) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing != nil {
+		return ErrClientAlreadyOpened
+	}
+
+	if err := c.loadPositions(); err != nil {
+		return err
+	}
+
+	// Files without a saved position are followed from their end, only
+	// files appearing later, such as after a rotation, are read from start.
+	for _, path := range c.match() {
+		if err := c.follow(path, true); err != nil {
+			c.Logger.Printf("failed to follow %s: %s", path, err)
+		}
+	}
+
+	c.closing = make(chan struct{})
+	c.wg.Add(1)
+	go c.poll()
+
+	return nil
+}
+
+func (c *TailClient) Close() error {
+	c.mu.Lock()
+	if c.closing == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	close(c.closing)
+	c.mu.Unlock()
+
+	c.wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.savePositions()
+	for path, tf := range c.files {
+		tf.f.Close()
+		delete(c.files, path)
+	}
+	c.closing = nil
+	return err
+}
+
+// Read blocks until a line has been appended to any of the files.
+func (c *TailClient) Read() ([]byte, error) {
+	select {
+	case line := <-c.lines:
+		return line, nil
+	case <-c.closing:
+		return nil, ErrClientClosed
+	}
+}
+
+func (c *TailClient) poll() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closing:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		for _, path := range c.match() {
+			if _, ok := c.files[path]; !ok {
+				if err := c.follow(path, false); err != nil {
+					c.Logger.Printf("failed to follow %s: %s", path, err)
+				}
+			}
+		}
+		paths := make([]string, 0, len(c.files))
+		for path := range c.files {
+			paths = append(paths, path)
+		}
+		c.mu.Unlock()
+
+		for _, path := range paths {
+			if err := c.check(path); err != nil {
+				c.Logger.Printf("failed to read %s: %s", path, err)
+			}
+		}
+
+		c.mu.Lock()
+		if err := c.savePositions(); err != nil {
+			c.Logger.Printf("failed to save positions: %s", err)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// match returns every file matching the configured patterns.
+func (c *TailClient) match() []string {
+	var paths []string
+	for _, pattern := range c.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			c.Logger.Printf("invalid pattern %s: %s", pattern, err)
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// follow opens path and seeks to its saved position, to its end if atEnd is
+// set and nothing was saved, or to its start otherwise.
+func (c *TailClient) follow(path string, atEnd bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var offset int64
+	if pos, ok := c.positions[path]; ok {
+		if pos.FileID == fileID(info) && pos.Offset <= info.Size() {
+			offset = pos.Offset
+		}
+	} else if atEnd {
+		offset = info.Size()
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	c.files[path] = &tailedFile{f: f, info: info, offset: offset}
+	c.positions[path] = position{Offset: offset, FileID: fileID(info)}
+	return nil
+}
+
+// check reads what was appended to path and handles rotation.
+func (c *TailClient) check(path string) error {
+	c.mu.Lock()
+	tf := c.files[path]
+	c.mu.Unlock()
+
+	// Finish the current file first, rotation must not lose its last lines.
+	if err := c.readFile(path, tf); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		// Renamed away and not recreated yet, keep reading the old file.
+		return nil
+	case err != nil:
+		return err
+	case !os.SameFile(info, tf.info):
+		// Renamed and recreated, the new file is read from its start.
+		tf.f.Close()
+		c.mu.Lock()
+		delete(c.files, path)
+		delete(c.positions, path)
+		err := c.follow(path, false)
+		c.mu.Unlock()
+		return err
+	case info.Size() < tf.offset:
+		// Truncated in place.
+		if _, err := tf.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		tf.partial = nil
+		c.setOffset(path, tf, 0)
+		return c.readFile(path, tf)
+	}
+	return nil
+}
+
+// readFile sends every complete line appended to tf.
+func (c *TailClient) readFile(path string, tf *tailedFile) error {
+	data, err := ioutil.ReadAll(tf.f)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	offset := tf.offset + int64(len(data))
+	data = append(tf.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	tf.partial = append([]byte(nil), data[end+1:]...)
+
+	for _, line := range bytes.Split(data[:end+1], []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		p, err := c.parser.Point(string(line))
+		if err != nil {
+			c.Logger.Printf("failed to parse line of %s: %s", path, err)
+			continue
+		}
+
+		select {
+		case c.lines <- []byte(p.String()):
+		case <-c.closing:
+			return nil
+		}
+	}
+
+	c.setOffset(path, tf, offset)
+	return nil
+}
+
+// setOffset records that tf was read up to offset, the saved position
+// excludes the trailing partial line so it is read again after a restart.
+func (c *TailClient) setOffset(path string, tf *tailedFile, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tf.offset = offset
+	c.positions[path] = position{Offset: offset - int64(len(tf.partial)), FileID: fileID(tf.info)}
+}
+
+func (c *TailClient) loadPositions() error {
+	if c.positionPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(c.positionPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.positions)
+}
+
+// savePositions atomically replaces the position file.
+func (c *TailClient) savePositions() error {
+	if c.positionPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(c.positions)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.positionPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.positionPath)
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	itoml "github.com/influxdata/influxdb/toml"
+)
+
+func newTestTailClient(t *testing.T, dir string) *TailClient {
+	c, err := NewTailClient(&InputConfig{
+		Protocol:     "tail",
+		Files:        []string{filepath.Join(dir, "access.log")},
+		LogFormat:    "$server_name $status $request_time",
+		PositionFile: filepath.Join(dir, "positions.json"),
+		PollInterval: itoml.Duration(10 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("failed to create tail client: %s", err)
+	}
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open tail client: %s", err)
+	}
+	return c
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		fmt.Fprintln(f, line)
+	}
+}
+
+func expectRead(t *testing.T, c *TailClient, serverName string) {
+	buf, err := c.Read()
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	if !strings.Contains(string(buf), "server_name="+serverName) {
+		t.Errorf("Expected a point of %s but found %s", serverName, buf)
+	}
+}
+
+func TestTailClient_Rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-filter-tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	// Lines written before the first start are skipped.
+	appendLines(t, path, "old 200 0.1")
+	c := newTestTailClient(t, dir)
+
+	appendLines(t, path, "a 200 0.1")
+	expectRead(t, c, "a")
+
+	// Rotation by rename, the last lines of the old file come first.
+	appendLines(t, path, "b 200 0.1")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "c 200 0.1")
+	expectRead(t, c, "b")
+	expectRead(t, c, "c")
+
+	// Rotation by truncation.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendLines(t, path, "d 200 0.1")
+	expectRead(t, c, "d")
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	// Lines written while stopped are read after a restart.
+	appendLines(t, path, "e 200 0.1")
+	c = newTestTailClient(t, dir)
+	defer c.Close()
+	expectRead(t, c, "e")
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// TCPClient accepts newline delimited line protocol over many concurrent
+// tcp connections and hands every line to Read.
+type TCPClient struct {
+	mu sync.Mutex
+
+	bindAddr       string
+	maxLineSize    int
+	idleTimeout    time.Duration
+	maxConnections int
+
+	ln    net.Listener
+	conns map[net.Conn]struct{}
+
+	lines   chan packet
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	Logger *log.Logger
+}
+
+func NewTCPClient(config *InputConfig) *TCPClient {
+	c := &TCPClient{
+		bindAddr:       config.BindAddress,
+		maxLineSize:    config.BufferSize,
+		idleTimeout:    time.Duration(config.IdleTimeout),
+		maxConnections: config.MaxConnections,
+		conns:          make(map[net.Conn]struct{}),
+		lines:          make(chan packet),
+		Logger:         log.New(os.Stderr, "[tcp] ", log.LstdFlags),
+	}
+	if c.maxLineSize == 0 {
+		c.maxLineSize = DefaultTCPBufferSize
+	}
+	if c.idleTimeout == 0 {
+		c.idleTimeout = DefaultIdleTimeout
+	}
+	return c
+}
+
+func (c *TCPClient) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ln != nil {
+		return ErrClientAlreadyOpened
+	}
+
+	ln, err := net.Listen("tcp", c.bindAddr)
+	if err != nil {
+		return err
+	}
+	c.ln = ln
+	c.closing = make(chan struct{})
+
+	c.wg.Add(1)
+	go c.serve(ln)
+
+	return nil
+}
+
+// Addr returns the address the client listens on, nil if it is not opened.
+func (c *TCPClient) Addr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ln == nil {
+		return nil
+	}
+	return c.ln.Addr()
+}
+
+func (c *TCPClient) Close() error {
+	c.mu.Lock()
+	if c.ln == nil {
+		c.mu.Unlock()
+		return nil
+	}
+
+	close(c.closing)
+	err := c.ln.Close()
+	c.ln = nil
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	return err
+}
+
+// Read blocks until a line is received from any connection.
+func (c *TCPClient) Read() ([]byte, error) {
+	buf, _, err := c.ReadFrom()
+	return buf, err
+}
+
+// ReadFrom is Read returning the remote address of the connection.
+func (c *TCPClient) ReadFrom() ([]byte, net.Addr, error) {
+	select {
+	case line := <-c.lines:
+		return line.buf, line.addr, nil
+	case <-c.closing:
+		return nil, nil, ErrClientClosed
+	}
+}
+
+func (c *TCPClient) serve(ln net.Listener) {
+	defer c.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-c.closing:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				c.Logger.Printf("temporary accept error: %s", err)
+				continue
+			}
+			c.Logger.Printf("failed to accept connection: %s", err)
+			return
+		}
+
+		if !c.track(conn) {
+			c.Logger.Printf("too many connections, rejecting %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		c.wg.Add(1)
+		go c.handleConn(conn)
+	}
+}
+
+// track registers conn, it returns false if the connection limit is reached.
+func (c *TCPClient) track(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxConnections > 0 && len(c.conns) >= c.maxConnections {
+		return false
+	}
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *TCPClient) untrack(conn net.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+}
+
+func (c *TCPClient) handleConn(conn net.Conn) {
+	defer c.wg.Done()
+	defer c.untrack(conn)
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), c.maxLineSize)
+	for {
+		if c.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		buf := make([]byte, len(line))
+		copy(buf, line)
+
+		select {
+		case c.lines <- packet{buf: buf, addr: conn.RemoteAddr()}:
+		case <-c.closing:
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-c.closing:
+		default:
+			c.Logger.Printf("closing connection from %s: %s", conn.RemoteAddr(), err)
+		}
+	}
+}
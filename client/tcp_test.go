@@ -0,0 +1,88 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPClient_Read(t *testing.T) {
+	c := NewTCPClient(&InputConfig{BindAddress: "127.0.0.1:0"})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open tcp client: %s", err)
+	}
+	defer c.Close()
+
+	lines := []string{
+		"requests,host=a path=/ping response_time=0.001",
+		"requests,host=b path=/ping response_time=0.002",
+	}
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", c.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %s", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(lines[i] + "\n\n")); err != nil {
+			t.Fatalf("failed to write: %s", err)
+		}
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < len(lines); i++ {
+		buf, err := c.Read()
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		got[string(buf)] = true
+	}
+	for _, line := range lines {
+		if !got[line] {
+			t.Errorf("Expected line %q to be read", line)
+		}
+	}
+}
+
+func TestTCPClient_IdleTimeout(t *testing.T) {
+	c := NewTCPClient(&InputConfig{BindAddress: "127.0.0.1:0"})
+	c.idleTimeout = 50 * time.Millisecond
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open tcp client: %s", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("tcp", c.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected idle connection to be closed by the client")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("Expected idle connection to be closed before the deadline")
+	}
+}
+
+func TestTCPClient_DefaultIdleTimeout(t *testing.T) {
+	// a config parsed from toml without idle-timeout
+	c := NewTCPClient(&InputConfig{Protocol: "tcp", BindAddress: "127.0.0.1:0"})
+	if c.idleTimeout != DefaultIdleTimeout {
+		t.Errorf("Expected the idle timeout %s but found %s", DefaultIdleTimeout, c.idleTimeout)
+	}
+}
+
+func TestTCPClient_Close(t *testing.T) {
+	c := NewTCPClient(&InputConfig{BindAddress: "127.0.0.1:0"})
+	if err := c.Open(); err != nil {
+		t.Fatalf("failed to open tcp client: %s", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close tcp client: %s", err)
+	}
+	if _, err := c.Read(); err != ErrClientClosed {
+		t.Errorf("Expected %v but found %v", ErrClientClosed, err)
+	}
+}
@@ -130,6 +130,14 @@ func (m *Main) Run(args ...string) error {
 		if err := run.NewPrintConfigCommand().Run(args...); err != nil {
 			return fmt.Errorf("config: %s", err)
 		}
+	case "replay":
+		if err := run.NewReplayCommand().Run(args...); err != nil {
+			return fmt.Errorf("replay: %s", err)
+		}
+	case "templates":
+		if err := run.NewTemplatesCommand().Run(args...); err != nil {
+			return fmt.Errorf("templates: %s", err)
+		}
 	case "version":
 		if err := NewVersionCommand().Run(args...); err != nil {
 			return fmt.Errorf("version: %s", err)
@@ -176,7 +184,9 @@ The commands are:
 
 config               display the default configuration
 help                 display this help message
+replay               recompute windows of captured line protocol
 run                  run node with existing configuration
+templates            print the learned path templates as path rules
 version              displays the esm-filter version
 
 run is the default command.
@@ -1,5 +1,10 @@
 package mapreduce
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 // MapperCollector is a channel that collects the output from mapper tasks
 type MapperCollector chan chan interface{}
 
@@ -9,36 +14,201 @@ type MapperFunc func(interface{}, chan interface{})
 // ReducerFunc is a function that performs the reduce part of the MapReduce job
 type ReducerFunc func(chan interface{}, chan interface{})
 
-func mapperDispatcher(mapper MapperFunc, input chan interface{}, collector MapperCollector) {
+// Overflow is what the dispatcher does with an item when the queue of the
+// workers is full
+type Overflow int
+
+const (
+	// Block waits for a worker to take an item from the queue
+	Block Overflow = iota
+	// DropNewest drops the item which does not fit in the queue
+	DropNewest
+	// DropOldest drops the oldest item of the queue to make room, in
+	// ordered jobs the dispatcher still waits once the outputs of the
+	// dropped items fill the collector
+	DropOldest
+)
+
+const (
+	MaxWorkers = 10
+	// DefaultQueueDepth is the number of items waiting for a worker
+	DefaultQueueDepth = 1000
+)
+
+// Stats counts the items of every MapReduce job sharing it, its fields are
+// updated atomically.
+type Stats struct {
+	// Mapped is the number of items handed to the mapper
+	Mapped int64
+	// Blocked is the number of items the dispatcher waited to queue
+	Blocked int64
+	// DroppedNewest is the number of items dropped as the queue was full
+	DroppedNewest int64
+	// DroppedOldest is the number of queued items dropped to make room
+	DroppedOldest int64
+}
+
+type options struct {
+	workers    int
+	queueDepth int
+	overflow   Overflow
+	ordered    bool
+	stats      *Stats
+}
+
+// Option sets how a MapReduce job dispatches its items.
+type Option func(*options)
+
+// WithWorkers sets the number of goroutines running the mapper.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithQueueDepth sets the number of items waiting for a worker.
+func WithQueueDepth(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.queueDepth = n
+		}
+	}
+}
+
+// WithOverflow sets what happens to the items which do not fit in the queue.
+func WithOverflow(overflow Overflow) Option {
+	return func(o *options) {
+		o.overflow = overflow
+	}
+}
+
+// Ordered hands the mapper outputs to the reducer in the order of their
+// items, a slow item then holds back every output behind it. By default
+// outputs are reduced as soon as they are ready.
+func Ordered() Option {
+	return func(o *options) {
+		o.ordered = true
+	}
+}
+
+// WithStats counts the items of the job in stats.
+func WithStats(stats *Stats) Option {
+	return func(o *options) {
+		o.stats = stats
+	}
+}
+
+// task is an item waiting for a worker, along with the channel its mapper
+// output is sent to, its own one when outputs are collected in order
+type task struct {
+	item   interface{}
+	output chan interface{}
+}
+
+func worker(mapper MapperFunc, queue chan task, stats *Stats) {
+	for t := range queue {
+		atomic.AddInt64(&stats.Mapped, 1)
+		mapper(t.item, t.output)
+	}
+}
+
+// mapperDispatcher queues the items for the workers, the outputs of
+// ordered jobs are collected in the order of their items, the others are
+// sent straight to output.
+func mapperDispatcher(input chan interface{}, queue chan task, collector MapperCollector, output chan interface{}, o *options) {
 	for item := range input {
-		taskOutput := make(chan interface{})
-		go mapper(item, taskOutput)
-		collector <- taskOutput
+		t := task{item: item, output: output}
+		if o.ordered {
+			// the output is buffered so that workers do not wait for
+			// the reducer to take it
+			t.output = make(chan interface{}, 1)
+		}
+		select {
+		case queue <- t:
+			if o.ordered {
+				collector <- t.output
+			}
+			continue
+		default:
+		}
+
+		switch o.overflow {
+		case DropNewest:
+			atomic.AddInt64(&o.stats.DroppedNewest, 1)
+			continue
+		case DropOldest:
+			select {
+			case old := <-queue:
+				if o.ordered {
+					// its output is closed so that the reducer
+					// skips it
+					close(old.output)
+				}
+				atomic.AddInt64(&o.stats.DroppedOldest, 1)
+			default:
+			}
+		default:
+			atomic.AddInt64(&o.stats.Blocked, 1)
+		}
+		// only the dispatcher queues items, so there is room unless the
+		// policy is to block
+		queue <- t
+		if o.ordered {
+			collector <- t.output
+		}
+	}
+	close(queue)
+	if o.ordered {
+		close(collector)
 	}
-	close(collector)
 }
 
 // reduceDispatcher is responsible to listen on the collector channel and push each item
 // as input as Reducer task
 func reduceDispatcher(collector MapperCollector, reducerInput chan interface{}) {
 	for output := range collector {
-		reducerInput <- <-output
+		if v, ok := <-output; ok {
+			reducerInput <- v
+		}
 	}
 	close(reducerInput)
 }
 
-const (
-	MaxWorkers = 10
-)
+func MapReduce(mapper MapperFunc, reducer ReducerFunc, input chan interface{}, opts ...Option) interface{} {
+	o := &options{workers: MaxWorkers, queueDepth: DefaultQueueDepth, stats: &Stats{}}
+	for _, opt := range opts {
+		opt(o)
+	}
 
-func MapReduce(mapper MapperFunc, reducer ReducerFunc, input chan interface{}) interface{} {
 	reducerInput := make(chan interface{})
 	reducerOutput := make(chan interface{})
-	mapperCollector := make(MapperCollector, MaxWorkers)
-
+	queue := make(chan task, o.queueDepth)
 	go reducer(reducerInput, reducerOutput)
-	go reduceDispatcher(mapperCollector, reducerInput)
-	go mapperDispatcher(mapper, input, mapperCollector)
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(mapper, queue, o.stats)
+		}()
+	}
+
+	if o.ordered {
+		// the collector holds the outputs of every queued and running item
+		mapperCollector := make(MapperCollector, o.queueDepth+o.workers)
+		go reduceDispatcher(mapperCollector, reducerInput)
+		go mapperDispatcher(input, queue, mapperCollector, nil, o)
+	} else {
+		// the reducer input is closed once every worker is done
+		go func() {
+			wg.Wait()
+			close(reducerInput)
+		}()
+		go mapperDispatcher(input, queue, nil, reducerInput, o)
+	}
 
 	return <-reducerOutput
 }
@@ -0,0 +1,165 @@
+package mapreduce
+
+import (
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// collect reduces the items into a slice in the order they are received.
+func collect(input chan interface{}, output chan interface{}) {
+	var items []int
+	for item := range input {
+		items = append(items, item.(int))
+	}
+	output <- items
+}
+
+func TestMapReduce_Ordered(t *testing.T) {
+	input := make(chan interface{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			input <- i
+		}
+		close(input)
+	}()
+
+	stats := &Stats{}
+	mapper := func(item interface{}, output chan interface{}) { output <- item }
+	items := MapReduce(mapper, collect, input, WithWorkers(4), WithQueueDepth(2), WithStats(stats), Ordered()).([]int)
+	if len(items) != 1000 {
+		t.Fatalf("Expected %d items but found %d", 1000, len(items))
+	}
+	for i, item := range items {
+		if item != i {
+			t.Fatalf("Expected item %d at %d but found %d", i, i, item)
+		}
+	}
+	if stats.Mapped != 1000 || stats.DroppedNewest != 0 || stats.DroppedOldest != 0 {
+		t.Errorf("Expected 1000 mapped items and none dropped but found %+v", *stats)
+	}
+}
+
+func TestMapReduce_Unordered(t *testing.T) {
+	input := make(chan interface{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			input <- i
+		}
+		close(input)
+	}()
+
+	// every other item is slow, the others must not wait for them
+	mapper := func(item interface{}, output chan interface{}) {
+		if item.(int)%2 == 0 {
+			time.Sleep(time.Microsecond)
+		}
+		output <- item
+	}
+	items := MapReduce(mapper, collect, input, WithWorkers(4), WithQueueDepth(2)).([]int)
+	if len(items) != 1000 {
+		t.Fatalf("Expected %d items but found %d", 1000, len(items))
+	}
+	sort.Ints(items)
+	for i, item := range items {
+		if item != i {
+			t.Fatalf("Expected item %d once but found %d", i, item)
+		}
+	}
+}
+
+func TestMapReduce_Overflow(t *testing.T) {
+	for _, tt := range []struct {
+		overflow Overflow
+		ordered  bool
+		n        int
+		exp      []int
+		stats    Stats
+	}{
+		{overflow: DropNewest, n: 5, exp: []int{1, 2}, stats: Stats{Mapped: 2, DroppedNewest: 3}},
+		{overflow: DropOldest, n: 5, exp: []int{1, 5}, stats: Stats{Mapped: 2, DroppedOldest: 3}},
+		{overflow: DropNewest, ordered: true, n: 5, exp: []int{1, 2}, stats: Stats{Mapped: 2, DroppedNewest: 3}},
+		// the outputs of dropped items still wait in the collector
+		{overflow: DropOldest, ordered: true, n: 3, exp: []int{1, 3}, stats: Stats{Mapped: 2, DroppedOldest: 1}},
+	} {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		// the single worker is busy with the first item until released
+		mapper := func(item interface{}, output chan interface{}) {
+			if item.(int) == 1 {
+				close(started)
+				<-release
+			}
+			output <- item
+		}
+
+		stats := &Stats{}
+		input := make(chan interface{})
+		go func() {
+			input <- 1
+			<-started
+			for i := 2; i <= tt.n; i++ {
+				input <- i
+			}
+			close(input)
+			// release the worker once the dispatcher has dropped the items
+			for atomic.LoadInt64(&stats.DroppedNewest)+atomic.LoadInt64(&stats.DroppedOldest) < int64(tt.n-len(tt.exp)) {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+		}()
+
+		opts := []Option{WithWorkers(1), WithQueueDepth(1), WithOverflow(tt.overflow), WithStats(stats)}
+		if tt.ordered {
+			opts = append(opts, Ordered())
+		}
+		items := MapReduce(mapper, collect, input, opts...).([]int)
+		if !reflect.DeepEqual(items, tt.exp) {
+			t.Errorf("overflow %d, ordered %v: Expected the items %v but found %v", tt.overflow, tt.ordered, tt.exp, items)
+		}
+		if *stats != tt.stats {
+			t.Errorf("overflow %d, ordered %v: Expected the stats %+v but found %+v", tt.overflow, tt.ordered, tt.stats, *stats)
+		}
+	}
+}
+
+// benchmarkMapReduce maps b.N items, one in every 64 of them is slow as a
+// point with many fields would be.
+func benchmarkMapReduce(b *testing.B, opts ...Option) {
+	mapper := func(item interface{}, output chan interface{}) {
+		if item.(int)%64 == 0 {
+			time.Sleep(100 * time.Microsecond)
+		}
+		output <- item
+	}
+	count := func(input chan interface{}, output chan interface{}) {
+		var n int
+		for range input {
+			n++
+		}
+		output <- n
+	}
+
+	input := make(chan interface{})
+	go func() {
+		for i := 0; i < b.N; i++ {
+			input <- i
+		}
+		close(input)
+	}()
+
+	b.ResetTimer()
+	if n := MapReduce(mapper, count, input, opts...).(int); n != b.N {
+		b.Fatalf("Expected %d items but found %d", b.N, n)
+	}
+}
+
+func BenchmarkMapReduce_Ordered(b *testing.B) {
+	benchmarkMapReduce(b, Ordered())
+}
+
+func BenchmarkMapReduce_Unordered(b *testing.B) {
+	benchmarkMapReduce(b)
+}
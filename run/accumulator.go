@@ -0,0 +1,40 @@
+package run
+
+import (
+	"time"
+
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+// accumulator is the mapreduce job of a single window. Only the ingest loop
+// adds messages to it, and it is swapped for a new one at the end of every
+// window, so every message is counted in exactly one window.
+type accumulator struct {
+	input   chan interface{}
+	results chan map[string]RequestStatReducer
+
+	// end is the end of the window, set when the accumulator is swapped
+	end time.Time
+}
+
+func newAccumulator(mapper *Mapper, opts ...mapreduce.Option) *accumulator {
+	a := &accumulator{
+		input:   make(chan interface{}),
+		results: make(chan map[string]RequestStatReducer, 1),
+	}
+	go func() {
+		a.results <- mapreduce.MapReduce(mapper.Map, reducer, a.input, opts...).(map[string]RequestStatReducer)
+	}()
+	return a
+}
+
+// Add hands m to the mapreduce job, it must not be called after Close.
+func (a *accumulator) Add(m *message) {
+	a.input <- m
+}
+
+// Close ends the mapreduce job and returns its results.
+func (a *accumulator) Close() map[string]RequestStatReducer {
+	close(a.input)
+	return <-a.results
+}
@@ -0,0 +1,86 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// apdexZone is how a request counts in the Apdex score.
+type apdexZone int
+
+const (
+	// apdexNone is the zone of requests no threshold applies to
+	apdexNone apdexZone = iota
+	apdexSatisfied
+	apdexTolerating
+	apdexFrustrated
+)
+
+// apdexRule holds the thresholds of a server_name and path in seconds.
+type apdexRule struct {
+	serverName string
+	path       *regexp.Regexp
+	satisfied  float64
+	tolerating float64
+}
+
+func (r *apdexRule) match(serverName, path string) bool {
+	if r.serverName != "" && r.serverName != serverName {
+		return false
+	}
+	return r.path == nil || r.path.MatchString(path)
+}
+
+// apdexRules finds the zone of every request with the first rule matching it.
+type apdexRules []*apdexRule
+
+func newApdexRules(c *client.Config) (apdexRules, error) {
+	var rules apdexRules
+	for _, ac := range c.Apdex {
+		r := &apdexRule{
+			serverName: ac.ServerName,
+			satisfied:  time.Duration(ac.Satisfied).Seconds(),
+			tolerating: time.Duration(ac.Tolerating).Seconds(),
+		}
+		if r.tolerating == 0 {
+			r.tolerating = 4 * r.satisfied
+		}
+		if ac.Path != "" {
+			re, err := regexp.Compile(ac.Path)
+			if err != nil {
+				return nil, fmt.Errorf("apdex %q: %s", ac.Path, err)
+			}
+			r.path = re
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Zone returns the zone of a request, failed requests are frustrated
+// whatever their response time.
+func (rules apdexRules) Zone(serverName, path string, rs RequestStatMapper) apdexZone {
+	if !rs.timed && rs.outcome != outcomeFailure {
+		return apdexNone
+	}
+
+	for _, r := range rules {
+		if !r.match(serverName, path) {
+			continue
+		}
+		switch {
+		case rs.outcome == outcomeFailure:
+			return apdexFrustrated
+		case rs.responseTime <= r.satisfied:
+			return apdexSatisfied
+		case rs.responseTime <= r.tolerating:
+			return apdexTolerating
+		default:
+			return apdexFrustrated
+		}
+	}
+	return apdexNone
+}
@@ -0,0 +1,58 @@
+package run
+
+import (
+	"testing"
+	"time"
+
+	itoml "github.com/influxdata/influxdb/toml"
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestMapper_Apdex(t *testing.T) {
+	m, err := NewMapper(&client.Config{Apdex: []client.ApdexConfig{
+		{ServerName: "api", Path: "^/search", Satisfied: itoml.Duration(time.Second)},
+		{ServerName: "api", Satisfied: itoml.Duration(100 * time.Millisecond), Tolerating: itoml.Duration(200 * time.Millisecond)},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	test := "requests,server_name=api,path=/search,status_code=200 response_time=0.5\n" +
+		"requests,server_name=api,path=/search,status_code=200 response_time=3\n" +
+		"requests,server_name=api,path=/search,status_code=200 response_time=5\n" +
+		"requests,server_name=api,path=/users,status_code=200 response_time=0.05\n" +
+		"requests,server_name=api,path=/users,status_code=200 response_time=0.15\n" +
+		"requests,server_name=api,path=/users,status_code=500 response_time=0.01\n" +
+		"requests,server_name=api,path=/users,status_code=200 count=3\n" +
+		"requests,server_name=web,path=/users,status_code=200 response_time=0.01\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test), weighted: true}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	tests := []struct {
+		key                               string
+		satisfied, tolerating, frustrated int64
+		apdex                             float64
+	}{
+		{"requests,path=/search,server_name=api", 1, 1, 1, 0.5},
+		{"requests,path=/users,server_name=api", 1, 1, 1, 0.5},
+	}
+	for _, tt := range tests {
+		rsr := res[tt.key]
+		fields := rsr.Fields()
+		if fields["satisfied"] != tt.satisfied || fields["tolerating"] != tt.tolerating || fields["frustrated"] != tt.frustrated || fields["apdex"] != tt.apdex {
+			t.Errorf("%s: Expected %d/%d/%d and an apdex of %v but found %v", tt.key, tt.satisfied, tt.tolerating, tt.frustrated, tt.apdex, fields)
+		}
+		assertWireFields(t, fields, "satisfied=1i", "tolerating=1i", "frustrated=1i")
+	}
+
+	rsr := res["requests,path=/users,server_name=web"]
+	if _, ok := rsr.Fields()["apdex"]; ok {
+		t.Error("Expected no apdex without a matching threshold")
+	}
+}
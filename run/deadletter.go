@@ -0,0 +1,144 @@
+package run
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// deadLetter keeps the payloads which could not be parsed, so emitters can
+// be debugged without losing the server. Every record is a header line
+// starting with "#" followed by the raw payload, the file is rotated once
+// it grows past maxSize.
+type deadLetter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func newDeadLetter(c client.DeadLetterConfig) *deadLetter {
+	d := &deadLetter{
+		path:       c.Path,
+		maxSize:    c.MaxSize,
+		maxBackups: c.MaxBackups,
+	}
+	if d.maxSize == 0 {
+		d.maxSize = client.DefaultDeadLetterMaxSize
+	}
+	if d.maxBackups == 0 {
+		d.maxBackups = client.DefaultDeadLetterMaxBackups
+	}
+	return d
+}
+
+// Write appends the payload data read by input from source along with the
+// error it failed with.
+func (d *deadLetter) Write(t time.Time, input, source string, cause error, data []byte) error {
+	record := []byte(fmt.Sprintf("# time=%s input=%q source=%q bytes=%d error=%q\n",
+		t.Format(time.RFC3339Nano), input, source, len(data), cause.Error()))
+	record = append(record, data...)
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		record = append(record, '\n')
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.f != nil && d.size > 0 && d.size+int64(len(record)) > d.maxSize {
+		if err := d.rotate(); err != nil {
+			return err
+		}
+	}
+	if d.f == nil {
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := d.f.Write(record)
+	d.size += int64(n)
+	return err
+}
+
+func (d *deadLetter) open() error {
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.f, d.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames the file to path.1, shifting the older files up to
+// path.maxBackups.
+func (d *deadLetter) rotate() error {
+	err := d.f.Close()
+	d.f, d.size = nil, 0
+	if err != nil {
+		return err
+	}
+
+	for i := d.maxBackups; i > 1; i-- {
+		src := fmt.Sprintf("%s.%d", d.path, i-1)
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", d.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(d.path, d.path+".1")
+}
+
+func (d *deadLetter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
+
+// logLimiter logs at most one message per interval and counts the ones it
+// suppressed in between.
+type logLimiter struct {
+	mu         sync.Mutex
+	logger     *log.Logger
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+func newLogLimiter(logger *log.Logger, interval time.Duration) *logLimiter {
+	return &logLimiter{logger: logger, interval: interval}
+}
+
+func (l *logLimiter) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !l.last.IsZero() && now.Sub(l.last) < l.interval {
+		l.suppressed++
+		return
+	}
+
+	msg := fmt.Sprintf(format, v...)
+	if l.suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed)", msg, l.suppressed)
+	}
+	l.last, l.suppressed = now, 0
+	l.logger.Print(msg)
+}
@@ -0,0 +1,84 @@
+package run
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestDeadLetter_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letter")
+	d := newDeadLetter(client.DeadLetterConfig{Path: path, MaxSize: 100, MaxBackups: 2})
+	defer d.Close()
+
+	for _, data := range []string{"first", "second", "third", "fourth"} {
+		if err := d.Write(time.Unix(0, 0).UTC(), "edge-1", "127.0.0.1:5000", errors.New("bad"), []byte(data)); err != nil {
+			t.Fatalf("failed to write dead letter: %s", err)
+		}
+	}
+
+	for suffix, exp := range map[string]string{"": "fourth", ".1": "third", ".2": "second"} {
+		data, err := ioutil.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", path+suffix, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 || lines[1] != exp {
+			t.Errorf("%s: Expected a single record of %q but found %q", suffix, exp, data)
+		}
+		if !strings.HasPrefix(lines[0], `# time=1970-01-01T00:00:00Z input="edge-1" source="127.0.0.1:5000" bytes=`) {
+			t.Errorf("%s: unexpected header %q", suffix, lines[0])
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no more than 2 backups")
+	}
+}
+
+func TestMapper_DeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letter")
+	m, err := NewMapper(&client.Config{DeadLetter: client.DeadLetterConfig{Path: path}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+	defer m.Close()
+
+	test := "requests,path=/a response_time=0.1\n" +
+		"requests,path=/a response_time=\n" +
+		"requests,path=/a,status_code=20x count=1\n" +
+		"requests,path=/a,status_code=200 bytes=1\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{input: "edge-1", source: "127.0.0.1:5000", buf: []byte(test), weighted: true}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	if v := res["requests,input=edge-1,path=/a"].fields["totalRequestTimes"]; v != uint64(3) {
+		t.Errorf("Expected %d requests but found %v", 3, v)
+	}
+
+	stats := m.Statistics(nil)
+	values := stats[len(stats)-1].Values
+	for k, n := range map[string]int64{"parseErrors": 1, "invalidStatusCode": 1, "missingResponseTime": 1} {
+		if values[k] != n {
+			t.Errorf("Expected %d %s but found %v", n, k, values[k])
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read dead letter: %s", err)
+	}
+	if !strings.Contains(string(data), `source="127.0.0.1:5000"`) || !strings.HasSuffix(string(data), test) {
+		t.Errorf("Expected the raw payload in the dead letter but found %q", data)
+	}
+}
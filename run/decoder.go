@@ -0,0 +1,168 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// decoder parses a payload read from an input into points.
+type decoder interface {
+	Decode(buf []byte) ([]models.Point, error)
+}
+
+// newDecoder returns the decoder of the format of the input.
+func newDecoder(c *client.InputConfig) (decoder, error) {
+	switch c.Format {
+	case "", "line-protocol":
+		return lineProtocolDecoder{}, nil
+	case "json":
+		return newJSONDecoder(c.JSON), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", c.Format)
+	}
+}
+
+// lineProtocolDecoder decodes InfluxDB line protocol.
+type lineProtocolDecoder struct{}
+
+func (lineProtocolDecoder) Decode(buf []byte) ([]models.Point, error) {
+	return models.ParsePoints(buf)
+}
+
+// jsonDecoder decodes json records such as the ones written by an nginx
+// log_format with escape=json, one or more records per payload.
+type jsonDecoder struct {
+	config client.JSONConfig
+}
+
+// newJSONDecoder uses the nginx variable names for every key which is not
+// configured.
+func newJSONDecoder(c client.JSONConfig) *jsonDecoder {
+	def := client.NewJSONConfig()
+	if c.Measurement == "" {
+		c.Measurement = def.Measurement
+	}
+	if len(c.Tags) == 0 {
+		c.Tags = def.Tags
+	}
+	if c.StatusCodeKey == "" {
+		c.StatusCodeKey = def.StatusCodeKey
+	}
+	if c.ResponseTimeKey == "" {
+		c.ResponseTimeKey = def.ResponseTimeKey
+	}
+	if c.TimeKey == "" {
+		c.TimeKey = def.TimeKey
+	}
+	return &jsonDecoder{config: c}
+}
+
+// Decode skips the records which cannot be mapped to a point, such as the
+// ones with a request_time of "-", and reports them once the others are
+// decoded.
+func (d *jsonDecoder) Decode(buf []byte) ([]models.Point, error) {
+	var (
+		points  []models.Point
+		skipped int
+		first   error
+	)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	for {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			// the rest of the payload cannot be read past invalid json
+			return points, err
+		}
+
+		p, err := d.point(record)
+		if err != nil {
+			if skipped == 0 {
+				first = err
+			}
+			skipped++
+			continue
+		}
+		points = append(points, p)
+	}
+	if skipped > 0 {
+		return points, fmt.Errorf("skipped %d of %d records: %s", skipped, skipped+len(points), first)
+	}
+	return points, nil
+}
+
+// point maps record to a point, nginx quotes every value so numbers are
+// accepted both as json numbers and as strings.
+func (d *jsonDecoder) point(record map[string]interface{}) (models.Point, error) {
+	measurement := d.config.Measurement
+	if v := jsonString(record[d.config.MeasurementKey]); v != "" {
+		measurement = v
+	}
+
+	tags := make(map[string]string)
+	for key, tag := range d.config.Tags {
+		if v := jsonString(record[key]); v != "" {
+			tags[tag] = v
+		}
+	}
+	if v := jsonString(record[d.config.StatusCodeKey]); v != "" {
+		tags["status_code"] = v
+	}
+
+	fields := make(map[string]interface{})
+	if v := jsonString(record[d.config.ResponseTimeKey]); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", d.config.ResponseTimeKey, v, err)
+		}
+		fields["response_time"] = f
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("record has no %s", d.config.ResponseTimeKey)
+	}
+
+	t := time.Now().UTC()
+	if v := jsonString(record[d.config.TimeKey]); v != "" {
+		var err error
+		if t, err = jsonTime(v); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", d.config.TimeKey, v, err)
+		}
+	}
+
+	return models.NewPoint(measurement, models.NewTags(tags), fields, t)
+}
+
+// jsonString returns v as a string, "-" being nginx's empty value.
+func jsonString(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	}
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// jsonTime parses either RFC 3339 time or seconds since epoch, such as
+// nginx's $time_iso8601 and $msec.
+func jsonTime(s string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(f*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
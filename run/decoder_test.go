@@ -0,0 +1,99 @@
+package run
+
+import (
+	"testing"
+
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestJSONDecoder_Decode(t *testing.T) {
+	dec, err := newDecoder(&client.InputConfig{Format: "json"})
+	if err != nil {
+		t.Fatalf("failed to create decoder: %s", err)
+	}
+
+	buf := []byte(`{"hostname":"qcr-web-proxy-66","server_name":"restapi.ele.me","uri":"/ping","status":"503","request_time":"0.001","msec":"1481175443.530"}
+{"hostname":"qcr-web-proxy-66","server_name":"restapi.ele.me","uri":"/ping","status":200,"request_time":0.002,"upstream_addr":"-"}`)
+	points, err := dec.Decode(buf)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Expected %d points but found %d", 2, len(points))
+	}
+
+	p := points[0]
+	if p.Name() != "requests" {
+		t.Errorf("Expected measurement %s but found %s", "requests", p.Name())
+	}
+	exp := "requests,host=qcr-web-proxy-66,path=/ping,server_name=restapi.ele.me,status_code=503"
+	if string(p.Key()) != exp {
+		t.Errorf("Expected key %s but found %s", exp, p.Key())
+	}
+	if p.Fields()["response_time"] != 0.001 {
+		t.Errorf("Expected response_time %f but found %v", 0.001, p.Fields()["response_time"])
+	}
+	if p.Time().Unix() != 1481175443 {
+		t.Errorf("Expected time %d but found %d", 1481175443, p.Time().Unix())
+	}
+	if tags := points[1].Tags().Map(); tags["status_code"] != "200" {
+		t.Errorf("Expected status_code %s but found %s", "200", tags["status_code"])
+	}
+
+	if _, err := dec.Decode([]byte(`{"status":"200","request_time":"fast"}`)); err == nil {
+		t.Error("Expected an invalid request_time to be rejected")
+	}
+
+	// a bad record does not drop the ones after it
+	points, err = dec.Decode([]byte(`{"status":"200","request_time":"0.1"}
+{"status":"200","request_time":"-"}
+{"status":"200","request_time":"0.3"}`))
+	if err == nil {
+		t.Error("Expected the record without request_time to be reported")
+	}
+	if len(points) != 2 {
+		t.Errorf("Expected %d points but found %d", 2, len(points))
+	}
+}
+
+func TestJSONDecoder_Defaults(t *testing.T) {
+	// the keys which are not set use the nginx names
+	dec := newJSONDecoder(client.JSONConfig{Measurement: "edge", TimeKey: "ts"})
+	points, err := dec.Decode([]byte(`{"uri":"/ping","status":"200","request_time":"0.1","ts":"1481175443"}`))
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	p := points[0]
+	if exp := "edge,path=/ping,status_code=200"; string(p.Key()) != exp {
+		t.Errorf("Expected key %s but found %s", exp, p.Key())
+	}
+	if p.Time().Unix() != 1481175443 {
+		t.Errorf("Expected time %d but found %d", 1481175443, p.Time().Unix())
+	}
+}
+
+func TestServer_MapReduceJSON(t *testing.T) {
+	testKey := "requests,host=qcr-web-proxy-66,path=/ping,server_name=restapi.ele.me"
+	dec := newJSONDecoder(client.JSONConfig{
+		MeasurementKey:  "kind",
+		Tags:            map[string]string{"h": "host", "s": "server_name", "p": "path"},
+		StatusCodeKey:   "code",
+		ResponseTimeKey: "latency",
+	})
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{decoder: dec, buf: []byte(`{"kind":"requests","h":"qcr-web-proxy-66","s":"restapi.ele.me","p":"/ping","code":"404","latency":"0.5"}`)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(testMapper.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	value, ok := res[testKey]
+	if !ok {
+		t.Fatalf("Expected key %s in results but found %v", testKey, res)
+	}
+	if value.fields["404"].(uint64) != 1 {
+		t.Errorf("Expected %d 404 but found %d", 1, value.fields["404"])
+	}
+}
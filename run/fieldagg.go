@@ -0,0 +1,63 @@
+package run
+
+import (
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// fieldAggregation aggregates a numeric field of the points.
+type fieldAggregation struct {
+	field        string
+	aggregations []string
+	// percentiles holds the percentile of every aggregation, 0 for the
+	// aggregations which are not percentiles
+	percentiles []float64
+}
+
+func newFieldAggregations(c *client.Config) ([]fieldAggregation, error) {
+	var aggs []fieldAggregation
+	for _, fc := range c.FieldAggregations {
+		fa := fieldAggregation{field: fc.Field, aggregations: fc.Aggregations}
+		for _, a := range fc.Aggregations {
+			p, err := client.ParseAggregation(a)
+			if err != nil {
+				return nil, err
+			}
+			fa.percentiles = append(fa.percentiles, p)
+		}
+		aggs = append(aggs, fa)
+	}
+	return aggs, nil
+}
+
+// fieldValue returns the value of the numeric field key.
+func fieldValue(fields models.Fields, key string) (float64, bool) {
+	switch v := fields[key].(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// writeFields adds the aggregations of the values of s to fields.
+func (fa *fieldAggregation) writeFields(s *quantileSketch, fields map[string]interface{}) {
+	for i, a := range fa.aggregations {
+		name := fa.field + "_" + a
+		switch a {
+		case "sum":
+			fields[name] = s.sum
+		case "count":
+			fields[name] = int64(s.count)
+		case "min":
+			fields[name] = s.min
+		case "max":
+			fields[name] = s.max
+		case "mean":
+			fields[name] = s.Mean()
+		default:
+			fields[fa.field+"_"+percentileField(fa.percentiles[i])] = s.Quantile(fa.percentiles[i] / 100)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package run
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestMapper_FieldAggregations(t *testing.T) {
+	m, err := NewMapper(&client.Config{FieldAggregations: []client.FieldAggregationConfig{
+		{Field: "response_size", Aggregations: []string{"sum", "count", "min", "max", "mean", "p99.9"}},
+		{Field: "upstream_response_time", Aggregations: []string{"max"}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	test := "requests,path=/a response_time=0.1,response_size=100i\n" +
+		"requests,path=/a response_time=0.1,response_size=300\n" +
+		"requests,path=/a response_time=0.1\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	rsr := res["requests,path=/a"]
+	fields := rsr.Fields()
+	for k, exp := range map[string]interface{}{
+		"response_size_sum":   float64(400),
+		"response_size_count": int64(2),
+		"response_size_min":   float64(100),
+		"response_size_max":   float64(300),
+		"response_size_mean":  float64(200),
+	} {
+		if fields[k] != exp {
+			t.Errorf("%s: Expected %v but found %v", k, exp, fields[k])
+		}
+	}
+	if v, _ := fields["response_size_p999"].(float64); math.Abs(v-300)/300 > sketchAccuracy {
+		t.Errorf("Expected a p999 of about %v but found %v", 300, fields["response_size_p999"])
+	}
+	if _, ok := fields["upstream_response_time_max"]; ok {
+		t.Error("Expected no aggregation of a missing field")
+	}
+}
@@ -0,0 +1,597 @@
+package run
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	influxDBClient "github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+)
+
+type RequestStatMapper struct {
+	outcome      outcome
+	statusCode   int
+	responseTime float64
+	// timed is set if the request has a response_time
+	timed bool
+	apdex apdexZone
+	// count is the number of requests, more than one for sampled or
+	// counted metrics such as the ones of StatsD
+	count uint64
+	// values holds the fields of the field aggregations, NaN if missing
+	values []float64
+}
+
+// series holds the requests the mapper grouped under one key.
+type series struct {
+	measurement string
+	tags        map[string]string
+	// window is the start of the event-time window in nanoseconds, 0 when
+	// points are bucketed by arrival
+	window int64
+	spec   *aggregateSpec
+	stats  []RequestStatMapper
+}
+
+// aggregateSpec sets the fields computed for every aggregate besides the
+// request counts.
+type aggregateSpec struct {
+	percentiles []float64
+	// buckets are the upper bounds of the histogram, nil if it is disabled
+	buckets []float64
+	fields  []fieldAggregation
+	// statusClasses counts requests by status class, and by status code
+	// only for keepCodes
+	statusClasses bool
+	keepCodes     map[int]bool
+}
+
+func newAggregateSpec(c *client.Config) (*aggregateSpec, error) {
+	fields, err := newFieldAggregations(c)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &aggregateSpec{percentiles: c.Percentiles, fields: fields}
+	if c.StatusCodes.Mode == "class" {
+		spec.statusClasses = true
+		spec.keepCodes = make(map[int]bool)
+		for _, code := range c.StatusCodes.Keep {
+			spec.keepCodes[code] = true
+		}
+	}
+	if len(spec.percentiles) == 0 {
+		spec.percentiles = client.DefaultPercentiles
+	}
+	if c.Histogram.Enabled {
+		spec.buckets = c.Histogram.Buckets
+		if len(spec.buckets) == 0 {
+			spec.buckets = client.DefaultHistogramBuckets
+		}
+	}
+	return spec, nil
+}
+
+// statusClassField names the field counting the requests of the class of
+// code, "status_5xx" for 503.
+func statusClassField(code int) string {
+	if code < 100 || code > 599 {
+		return "status_other"
+	}
+	return fmt.Sprintf("status_%dxx", code/100)
+}
+
+// bucketField names the histogram field of the bucket bound b, "le_0.005".
+func bucketField(b float64) string {
+	return "le_" + strconv.FormatFloat(b, 'f', -1, 64)
+}
+
+// percentileField names the field of percentile p, "p999" for 99.9.
+func percentileField(p float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "", 1)
+}
+
+// Mapper turns the payloads read from inputs into request statistics
+// grouped by measurement and the configured tags.
+type Mapper struct {
+	groupTags map[string][]string
+	// window is the size of event-time windows, 0 when points are bucketed
+	// by arrival
+	window   time.Duration
+	spec     *aggregateSpec
+	paths    *pathNormalizer
+	outcomes *classifier
+	apdex    apdexRules
+	// learner is nil unless path learning is enabled
+	learner       *pathLearner
+	templatesFile string
+	// deadLetter is nil unless a dead-letter file is configured
+	deadLetter *deadLetter
+
+	stats MapperStatistics
+	logs  *logLimiter
+}
+
+// MapperStatistics keeps the counters of a Mapper.
+type MapperStatistics struct {
+	ParseErrors         int64
+	DeadLetterErrors    int64
+	InvalidStatusCode   int64
+	MissingResponseTime int64
+}
+
+func NewMapper(c *client.Config) (*Mapper, error) {
+	paths, err := newPathNormalizer(c)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes, err := newClassifier(c)
+	if err != nil {
+		return nil, err
+	}
+
+	apdex, err := newApdexRules(c)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := newAggregateSpec(c)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mapper{
+		groupTags: make(map[string][]string),
+		paths:     paths,
+		outcomes:  outcomes,
+		apdex:     apdex,
+		spec:      spec,
+		logs:      newLogLimiter(log.New(os.Stderr, "[mapper] ", log.LstdFlags), time.Second),
+	}
+	if c.Windowing == "event-time" {
+		m.window = c.BaseWindow()
+	}
+	if c.DeadLetter.Path != "" {
+		m.deadLetter = newDeadLetter(c.DeadLetter)
+	}
+	if c.PathLearning.Enabled {
+		m.learner = newPathLearner(c.PathLearning)
+		m.templatesFile = c.PathLearning.TemplatesFile
+	}
+	for _, mc := range c.Measurements {
+		m.groupTags[mc.Name] = mc.Tags
+	}
+	return m, nil
+}
+
+// GroupTags returns the tags points of measurement are grouped by.
+func (m *Mapper) GroupTags(measurement string) []string {
+	if tags, ok := m.groupTags[measurement]; ok {
+		return tags
+	}
+	return client.DefaultGroupTags
+}
+
+// Statistics returns the counters of the path rules and of the mapper.
+func (m *Mapper) Statistics(tags map[string]string) []models.Statistic {
+	return append(m.paths.Statistics(tags), models.Statistic{
+		Name: "esm_filter_mapper",
+		Tags: models.StatisticTags{}.Merge(tags),
+		Values: map[string]interface{}{
+			"parseErrors":         atomic.LoadInt64(&m.stats.ParseErrors),
+			"deadLetterErrors":    atomic.LoadInt64(&m.stats.DeadLetterErrors),
+			"invalidStatusCode":   atomic.LoadInt64(&m.stats.InvalidStatusCode),
+			"missingResponseTime": atomic.LoadInt64(&m.stats.MissingResponseTime),
+		},
+	})
+}
+
+// Close closes the dead-letter file.
+func (m *Mapper) Close() error {
+	if m.deadLetter == nil {
+		return nil
+	}
+	return m.deadLetter.Close()
+}
+
+// reject counts a payload which could not be parsed and writes it to the
+// dead-letter file.
+func (m *Mapper) reject(msg *message, err error) {
+	atomic.AddInt64(&m.stats.ParseErrors, 1)
+	m.logs.Printf("failed to parse %d bytes read by %q from %s: %s", len(msg.buf), msg.input, msg.source, err)
+	if m.deadLetter == nil {
+		return
+	}
+	if err := m.deadLetter.Write(time.Now().UTC(), msg.input, msg.source, err, msg.buf); err != nil {
+		atomic.AddInt64(&m.stats.DeadLetterErrors, 1)
+		m.logs.Printf("failed to write dead letter: %s", err)
+	}
+}
+
+// Templates returns the path templates learned by server_name, it is nil
+// unless path learning is enabled.
+func (m *Mapper) Templates() map[string][]string {
+	if m.learner == nil {
+		return nil
+	}
+	return m.learner.Templates()
+}
+
+// SaveTemplates writes the learned path templates to the configured file.
+func (m *Mapper) SaveTemplates() error {
+	if m.learner == nil || m.templatesFile == "" {
+		return nil
+	}
+	return m.learner.Save(m.templatesFile)
+}
+
+// normalizePath rewrites path with the configured rules, the paths no rule
+// matched are templated by the learner.
+func (m *Mapper) normalizePath(serverName, path string) string {
+	path, rewritten := m.paths.Normalize(path)
+	if !rewritten && m.learner != nil {
+		path = m.learner.Learn(serverName, path)
+	}
+	return path
+}
+
+// Map is the mapreduce.MapperFunc, it sends a map[string]*series keyed by
+// the series key of every group.
+func (m *Mapper) Map(input interface{}, output chan interface{}) {
+	msg := input.(*message)
+	dec := msg.decoder
+	if dec == nil {
+		dec = lineProtocolDecoder{}
+	}
+	//parse buf as Points which defined infludb, the points parsed before
+	//an error are still counted
+	points, err := dec.Decode(msg.buf)
+	if err != nil {
+		m.reject(msg, err)
+	}
+
+	o := make(map[string]*series)
+	for _, p := range points {
+		var status_code int64
+		measurement := p.Name()
+		tags := p.Tags()
+		if v := tags.GetString("status_code"); v != "" {
+			status_code, err = strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				// counted as a request of unknown outcome
+				status_code = 0
+				atomic.AddInt64(&m.stats.InvalidStatusCode, 1)
+				m.logs.Printf("invalid status_code %q read by %q from %s", v, msg.input, msg.source)
+			}
+		}
+
+		// outcomes and apdex match the raw path, series are grouped by the
+		// normalized one
+		serverName, rawPath := tags.GetString("server_name"), tags.GetString("path")
+		path := rawPath
+		if path != "" {
+			path = m.normalizePath(serverName, path)
+		}
+
+		groupTags := m.GroupTags(measurement)
+		group := make(map[string]string, len(groupTags)+1)
+		for _, k := range groupTags {
+			v := tags.GetString(k)
+			if k == "path" {
+				v = path
+			}
+			if v != "" {
+				group[k] = v
+			}
+		}
+		if msg.input != "" {
+			group["input"] = msg.input
+		}
+
+		// The key is escaped like a series key, so any tag value is safe.
+		mapKey := string(models.MakeKey([]byte(measurement), models.NewTags(group)))
+		var window int64
+		if m.window > 0 {
+			window = windowStart(p.Time(), m.window).UnixNano()
+			mapKey = strconv.FormatInt(window, 10) + " " + mapKey
+		}
+		s, ok := o[mapKey]
+		if !ok {
+			s = &series{measurement: measurement, tags: group, window: window, spec: m.spec}
+			o[mapKey] = s
+		}
+
+		fields := p.Fields()
+		rs := RequestStatMapper{}
+		switch value := fields["response_time"].(type) {
+		case float64:
+			rs.responseTime, rs.timed = value, true
+		case int64:
+			rs.responseTime, rs.timed = float64(value), true
+		default:
+			// StatsD counters carry no latency
+			if _, counted := fields["count"]; !counted || !msg.weighted {
+				atomic.AddInt64(&m.stats.MissingResponseTime, 1)
+				m.logs.Printf("response_time is missing in %s read by %q from %s", measurement, msg.input, msg.source)
+			}
+		}
+		rs.count = 1
+		if value, ok := fields["count"].(float64); ok && msg.weighted && value >= 0 && !math.IsInf(value, 0) {
+			rs.count = uint64(value + 0.5)
+		}
+		rs.statusCode = int(status_code)
+		rs.outcome = m.outcomes.Classify(serverName, rawPath, rs.statusCode, rs.responseTime)
+		rs.apdex = m.apdex.Zone(serverName, rawPath, rs)
+		if len(m.spec.fields) > 0 {
+			rs.values = make([]float64, len(m.spec.fields))
+			for i, fa := range m.spec.fields {
+				v, ok := fieldValue(fields, fa.field)
+				if !ok {
+					v = math.NaN()
+				}
+				rs.values[i] = v
+			}
+		}
+		s.stats = append(s.stats, rs)
+	}
+	output <- o
+}
+
+type RequestStatReducer struct {
+	measurement string
+	tags        map[string]string
+	window      int64
+	spec        *aggregateSpec
+	fields      map[string]interface{}
+	// latency holds the response times of the timed requests
+	latency *quantileSketch
+	// histogram counts the timed requests of each bucket, the last one
+	// counts the requests slower than every bound
+	histogram []uint64
+	// values holds the values of every field aggregation
+	values []*quantileSketch
+}
+
+func (rsr *RequestStatReducer) Update(value RequestStatMapper) {
+	n := value.count
+	if n == 0 {
+		n = 1
+	}
+	if value.timed && rsr.latency != nil {
+		rsr.latency.Add(value.responseTime, n)
+	}
+	if value.timed && rsr.histogram != nil {
+		rsr.histogram[sort.SearchFloat64s(rsr.spec.buckets, value.responseTime)] += n
+	}
+	if value.apdex != apdexNone {
+		// every zone is written once a request is scored
+		for _, zone := range []string{"satisfied", "tolerating", "frustrated"} {
+			if _, existed := rsr.fields[zone]; !existed {
+				rsr.fields[zone] = uint64(0)
+			}
+		}
+		zone := [...]string{apdexSatisfied: "satisfied", apdexTolerating: "tolerating", apdexFrustrated: "frustrated"}[value.apdex]
+		rsr.fields[zone] = rsr.fields[zone].(uint64) + n
+	}
+	for i, v := range value.values {
+		if !math.IsNaN(v) && i < len(rsr.values) {
+			rsr.values[i].Add(v, n)
+		}
+	}
+
+	if _, existed := rsr.fields["totalRequestTimes"]; !existed {
+		rsr.fields["totalRequestTimes"] = n
+	} else {
+		if val, ok := rsr.fields["totalRequestTimes"].(uint64); ok {
+			rsr.fields["totalRequestTimes"] = val + n
+		}
+	}
+
+	switch value.outcome {
+	case outcomeFailure:
+		if _, existed := rsr.fields["totalFailureTimes"]; !existed {
+			rsr.fields["totalFailureTimes"] = n
+		} else {
+			if val, ok := rsr.fields["totalFailureTimes"].(uint64); ok {
+				rsr.fields["totalFailureTimes"] = val + n
+			}
+		}
+	case outcomeUnknown:
+		// requests without a status code are neither successes nor failures
+		if _, existed := rsr.fields["totalUnknownTimes"]; !existed {
+			rsr.fields["totalUnknownTimes"] = n
+		} else {
+			if val, ok := rsr.fields["totalUnknownTimes"].(uint64); ok {
+				rsr.fields["totalUnknownTimes"] = val + n
+			}
+		}
+	}
+
+	if value.outcome != outcomeUnknown {
+		if rsr.spec != nil && rsr.spec.statusClasses {
+			rsr.addCount(statusClassField(value.statusCode), n)
+		}
+		if rsr.spec == nil || !rsr.spec.statusClasses || rsr.spec.keepCodes[value.statusCode] {
+			rsr.addCount(fmt.Sprintf("%d", value.statusCode), n)
+		}
+	}
+
+	if _, existed := rsr.fields["totalResponseTime"]; !existed {
+		rsr.fields["totalResponseTime"] = value.responseTime * float64(n)
+	} else {
+		if val, ok := rsr.fields["totalResponseTime"].(float64); ok {
+			rsr.fields["totalResponseTime"] = val + value.responseTime*float64(n)
+		}
+	}
+}
+
+// addCount adds n to the counter key.
+func (rsr *RequestStatReducer) addCount(key string, n uint64) {
+	val, _ := rsr.fields[key].(uint64)
+	rsr.fields[key] = val + n
+}
+
+// Merge adds the requests counted by other, which aggregates the same group.
+func (rsr *RequestStatReducer) Merge(other RequestStatReducer) {
+	if other.latency != nil {
+		if rsr.latency == nil {
+			rsr.latency = newQuantileSketch()
+		}
+		rsr.latency.Merge(other.latency)
+	}
+	if other.histogram != nil {
+		if rsr.histogram == nil {
+			rsr.histogram = make([]uint64, len(other.histogram))
+		}
+		for i, n := range other.histogram {
+			rsr.histogram[i] += n
+		}
+	}
+	for i, s := range other.values {
+		if i == len(rsr.values) {
+			rsr.values = append(rsr.values, newQuantileSketch())
+		}
+		rsr.values[i].Merge(s)
+	}
+
+	for k, v := range other.fields {
+		switch v := v.(type) {
+		case uint64:
+			val, _ := rsr.fields[k].(uint64)
+			rsr.fields[k] = val + v
+		case float64:
+			val, _ := rsr.fields[k].(float64)
+			rsr.fields[k] = val + v
+		}
+	}
+}
+
+// Fields returns the counts along with the percentiles, min, max and mean
+// of the response times, their cumulative histogram if enabled, the Apdex
+// score if any request was scored and the configured field aggregations.
+func (rsr *RequestStatReducer) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(rsr.fields)+len(rsr.histogram)+8)
+	for k, v := range rsr.fields {
+		fields[k] = v
+	}
+	// models writes uint64 fields as strings, the Apdex zones and status
+	// classes are written as integers so that they can be summed
+	for _, k := range []string{"satisfied", "tolerating", "frustrated"} {
+		if n, ok := rsr.fields[k].(uint64); ok {
+			fields[k] = int64(n)
+		}
+	}
+	if rsr.spec != nil && rsr.spec.statusClasses {
+		for k, v := range rsr.fields {
+			if n, ok := v.(uint64); ok && strings.HasPrefix(k, "status_") {
+				fields[k] = int64(n)
+			}
+		}
+	}
+
+	satisfied, _ := rsr.fields["satisfied"].(uint64)
+	tolerating, _ := rsr.fields["tolerating"].(uint64)
+	frustrated, _ := rsr.fields["frustrated"].(uint64)
+	if scored := satisfied + tolerating + frustrated; scored > 0 {
+		fields["apdex"] = (float64(satisfied) + float64(tolerating)/2) / float64(scored)
+	}
+
+	if rsr.spec != nil {
+		for i, s := range rsr.values {
+			if s.count > 0 && i < len(rsr.spec.fields) {
+				rsr.spec.fields[i].writeFields(s, fields)
+			}
+		}
+	}
+
+	if rsr.latency == nil || rsr.latency.count == 0 {
+		return fields
+	}
+
+	fields["min"] = rsr.latency.min
+	fields["max"] = rsr.latency.max
+	fields["mean"] = rsr.latency.Mean()
+	percentiles := client.DefaultPercentiles
+	if rsr.spec != nil {
+		percentiles = rsr.spec.percentiles
+	}
+	for _, p := range percentiles {
+		fields[percentileField(p)] = rsr.latency.Quantile(p / 100)
+	}
+
+	if rsr.histogram != nil {
+		var cumulative int64
+		for i, n := range rsr.histogram {
+			cumulative += int64(n)
+			if i < len(rsr.spec.buckets) {
+				fields[bucketField(rsr.spec.buckets[i])] = cumulative
+			} else {
+				fields["le_inf"] = cumulative
+			}
+		}
+	}
+	return fields
+}
+
+//map[string]RequestStatReducer
+func reducer(input chan interface{}, output chan interface{}) {
+	results := map[string]RequestStatReducer{}
+	for matches := range input {
+		for key, s := range matches.(map[string]*series) {
+			va, exists := results[key]
+			if !exists {
+				va = RequestStatReducer{measurement: s.measurement, tags: s.tags, window: s.window, spec: s.spec}
+				va.fields = make(map[string]interface{})
+				va.latency = newQuantileSketch()
+				if s.spec != nil && s.spec.buckets != nil {
+					va.histogram = make([]uint64, len(s.spec.buckets)+1)
+				}
+				if s.spec != nil {
+					for range s.spec.fields {
+						va.values = append(va.values, newQuantileSketch())
+					}
+				}
+			}
+			for _, value := range s.stats {
+				va.Update(value)
+			}
+			results[key] = va
+		}
+	}
+
+	output <- results
+}
+
+// aggregatePoints turns the results of a mapreduce job into points stamped
+// with t, every key and value is a point.
+func aggregatePoints(results interface{}, t time.Time) ([]*influxDBClient.Point, error) {
+	res, ok := results.(map[string]RequestStatReducer)
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		points   []*influxDBClient.Point
+		firstErr error
+	)
+	for _, value := range res {
+		p, err := influxDBClient.NewPoint(value.measurement, value.tags, value.Fields(), t)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		points = append(points, p)
+	}
+	return points, firstErr
+}
@@ -0,0 +1,215 @@
+package run
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	itoml "github.com/influxdata/influxdb/toml"
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestMapper_GroupTags(t *testing.T) {
+	m, err := NewMapper(&client.Config{
+		Measurements: []client.MeasurementConfig{{Name: "requests", Tags: []string{"upstream", "method"}}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+	test := "requests,host=a,upstream=127.0.0.1:8444,method=GET,path=/a\\,b response_time=0.001\n" +
+		"requests,host=b,upstream=127.0.0.1:8444,method=GET,path=/c response_time=0.002\n" +
+		"upstreams,host=a,server_name=s\\,t,path=/ping response_time=0.003\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{input: "edge-1", buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	results := mapreduce.MapReduce(m.Map, reducer, inputChan)
+	points, err := aggregatePoints(results, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed to create points: %s", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Expected %d points but found %d", 2, len(points))
+	}
+
+	exp := map[string]map[string]string{
+		"requests":  {"upstream": "127.0.0.1:8444", "method": "GET", "input": "edge-1"},
+		"upstreams": {"host": "a", "server_name": "s,t", "path": "/ping", "input": "edge-1"},
+	}
+	for _, p := range points {
+		if !reflect.DeepEqual(p.Tags(), exp[p.Name()]) {
+			t.Errorf("%s: Expected tags %v but found %v", p.Name(), exp[p.Name()], p.Tags())
+		}
+	}
+
+	res := results.(map[string]RequestStatReducer)
+	if v := res["requests,input=edge-1,method=GET,upstream=127.0.0.1:8444"].fields["totalRequestTimes"]; v != uint64(2) {
+		t.Errorf("Expected %d requests but found %v", 2, v)
+	}
+}
+
+func TestMapper_PathRules(t *testing.T) {
+	m, err := NewMapper(&client.Config{
+		StripQueryString: true,
+		PathRules: []client.PathRuleConfig{
+			{Pattern: `^/orders/\d+$`, Replacement: "/orders/:id"},
+			{Pattern: `^/users/\d+/(\w+)$`, Replacement: "/users/:id/$1"},
+			{Pattern: `^/ping$`, Replacement: "/ping"},
+			{Pattern: `^/orders/`, Replacement: "/never"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	test := "requests,path=/orders/812733 response_time=0.001\n" +
+		"requests,path=/orders/812734?page\\=2 response_time=0.001\n" +
+		"requests,path=/users/42/cart response_time=0.001\n" +
+		"requests,path=/ping?x\\=1 response_time=0.001\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	for key, n := range map[string]uint64{
+		"requests,path=/orders/:id":     2,
+		"requests,path=/users/:id/cart": 1,
+		"requests,path=/ping":           1,
+	} {
+		if v := res[key].fields["totalRequestTimes"]; v != n {
+			t.Errorf("%s: Expected %d requests but found %v", key, n, v)
+		}
+	}
+
+	stats := m.Statistics(nil)
+	// the /ping rule matches without changing the path
+	for i, n := range []int64{2, 1, 0, 0} {
+		if v := stats[i].Values["rewritten"]; v != n {
+			t.Errorf("rule %d: Expected %d rewrites but found %v", i, n, v)
+		}
+	}
+}
+
+func TestMapper_FailureRules(t *testing.T) {
+	m, err := NewMapper(&client.Config{
+		PathRules: []client.PathRuleConfig{
+			{Pattern: `^/orders/\d+$`, Replacement: "/orders/:id"},
+		},
+		FailureRules: []client.FailureRuleConfig{
+			{ServerName: "api", Path: `^/search`, Codes: []string{"5xx"}, LatencyThreshold: itoml.Duration(time.Second)},
+			// matched against the path before the rewrite
+			{ServerName: "api", Path: `^/orders/\d+$`, Codes: []string{"404"}},
+			{ServerName: "api", Codes: []string{"5xx", "429"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	test := "requests,server_name=api,path=/search,status_code=404 response_time=0.1\n" +
+		"requests,server_name=api,path=/search,status_code=200 response_time=1.5\n" +
+		"requests,server_name=api,path=/search,status_code=502 response_time=0.1\n" +
+		"requests,server_name=api,path=/search response_time=0.1\n" +
+		"requests,server_name=api,path=/users,status_code=429 response_time=0.1\n" +
+		"requests,server_name=api,path=/users,status_code=200 response_time=1.5\n" +
+		"requests,server_name=web,path=/users,status_code=404 response_time=0.1\n" +
+		"requests,server_name=api,path=/orders/7,status_code=404 response_time=0.1\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	tests := []struct {
+		key                      string
+		requests, fails, unknown interface{}
+	}{
+		{"requests,path=/search,server_name=api", uint64(4), uint64(2), uint64(1)},
+		{"requests,path=/users,server_name=api", uint64(2), uint64(1), nil},
+		{"requests,path=/users,server_name=web", uint64(1), uint64(1), nil},
+		{"requests,path=/orders/:id,server_name=api", uint64(1), uint64(1), nil},
+	}
+	for _, tt := range tests {
+		fields := res[tt.key].fields
+		if fields["totalRequestTimes"] != tt.requests || fields["totalFailureTimes"] != tt.fails || fields["totalUnknownTimes"] != tt.unknown {
+			t.Errorf("%s: Expected %v requests, %v failures and %v unknown but found %v", tt.key, tt.requests, tt.fails, tt.unknown, fields)
+		}
+	}
+	if _, ok := res["requests,path=/search,server_name=api"].fields["0"]; ok {
+		t.Error("Expected no status code field for unknown outcomes")
+	}
+}
+
+func TestMapper_Count(t *testing.T) {
+	test := "requests,server_name=api,path=/a,status_code=200 response_time=0.1,count=3"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{input: "lp", buf: []byte(test)}
+		inputChan <- &message{input: "statsd", buf: []byte(test), weighted: true}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(testMapper.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	for key, exp := range map[string]uint64{
+		"requests,input=lp,path=/a,server_name=api":     1,
+		"requests,input=statsd,path=/a,server_name=api": 3,
+	} {
+		if n := res[key].fields["totalRequestTimes"]; n != exp {
+			t.Errorf("%s: Expected %d requests but found %v", key, exp, n)
+		}
+	}
+}
+
+func TestMapper_StatusClasses(t *testing.T) {
+	m, err := NewMapper(&client.Config{StatusCodes: client.StatusCodesConfig{Mode: "class", Keep: []int{503}}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	test := "requests,path=/a,status_code=200 response_time=0.1\n" +
+		"requests,path=/a,status_code=204 response_time=0.1\n" +
+		"requests,path=/a,status_code=503 response_time=0.1\n" +
+		"requests,path=/a,status_code=500 response_time=0.1\n" +
+		"requests,path=/a,status_code=999 response_time=0.1\n" +
+		"requests,path=/a response_time=0.1\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	exp := map[string]interface{}{
+		"totalRequestTimes": uint64(6),
+		"totalFailureTimes": uint64(3),
+		"totalUnknownTimes": uint64(1),
+		"status_2xx":        uint64(2),
+		"status_5xx":        uint64(2),
+		"status_other":      uint64(1),
+		"503":               uint64(1),
+	}
+	fields := res["requests,path=/a"].fields
+	for k, v := range exp {
+		if fields[k] != v {
+			t.Errorf("%s: Expected %v but found %v", k, v, fields[k])
+		}
+	}
+	for _, k := range []string{"200", "500", "999"} {
+		if _, ok := fields[k]; ok {
+			t.Errorf("Expected no field for status code %s", k)
+		}
+	}
+	rsr := res["requests,path=/a"]
+	assertWireFields(t, rsr.Fields(), "status_2xx=2i", "status_5xx=2i", "status_other=1i")
+}
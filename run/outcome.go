@@ -0,0 +1,112 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// outcome is how a request counts against the SLA.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeUnknown is the outcome of requests without a status code
+	outcomeUnknown
+)
+
+// codeRange holds the status codes from min to max.
+type codeRange struct {
+	min, max int
+}
+
+// failureRule classifies the requests of a server_name and path.
+type failureRule struct {
+	serverName       string
+	path             *regexp.Regexp
+	codes            []codeRange
+	latencyThreshold float64
+}
+
+func newFailureRule(c client.FailureRuleConfig) (*failureRule, error) {
+	r := &failureRule{
+		serverName:       c.ServerName,
+		latencyThreshold: time.Duration(c.LatencyThreshold).Seconds(),
+	}
+	if c.Path != "" {
+		re, err := regexp.Compile(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failure rule %q: %s", c.Path, err)
+		}
+		r.path = re
+	}
+	for _, codes := range c.Codes {
+		min, max, err := client.ParseStatusCodes(codes)
+		if err != nil {
+			return nil, fmt.Errorf("failure rule: %s", err)
+		}
+		r.codes = append(r.codes, codeRange{min, max})
+	}
+	return r, nil
+}
+
+func (r *failureRule) match(serverName, path string) bool {
+	if r.serverName != "" && r.serverName != serverName {
+		return false
+	}
+	return r.path == nil || r.path.MatchString(path)
+}
+
+func (r *failureRule) classify(statusCode int, responseTime float64) outcome {
+	for _, c := range r.codes {
+		if statusCode >= c.min && statusCode <= c.max {
+			return outcomeFailure
+		}
+	}
+	if r.latencyThreshold > 0 && responseTime > r.latencyThreshold {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// classifier applies the configured failure rules, falling back to the
+// default status codes.
+type classifier struct {
+	rules       []*failureRule
+	defaultRule *failureRule
+}
+
+func newClassifier(c *client.Config) (*classifier, error) {
+	defaultRule, err := newFailureRule(client.FailureRuleConfig{Codes: client.DefaultFailureCodes})
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &classifier{defaultRule: defaultRule}
+	for _, rc := range c.FailureRules {
+		r, err := newFailureRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		cl.rules = append(cl.rules, r)
+	}
+	return cl, nil
+}
+
+// Classify returns the outcome of a request, statusCode is 0 if the request
+// has no status code.
+func (cl *classifier) Classify(serverName, path string, statusCode int, responseTime float64) outcome {
+	if statusCode == 0 {
+		return outcomeUnknown
+	}
+
+	for _, r := range cl.rules {
+		if r.match(serverName, path) {
+			return r.classify(statusCode, responseTime)
+		}
+	}
+	return cl.defaultRule.classify(statusCode, responseTime)
+}
@@ -0,0 +1,230 @@
+package run
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zhexuany/esm-filter/client"
+)
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashSegment    = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// pathNode is a segment of the paths learned for a server_name. Once a node
+// has seen more distinct children than the threshold, they are merged into a
+// single variable child standing for any value.
+type pathNode struct {
+	children    map[string]*pathNode
+	variable    *pathNode
+	placeholder string
+	// end is set if a path ends at this segment
+	end bool
+}
+
+func newPathNode() *pathNode {
+	return &pathNode{children: make(map[string]*pathNode)}
+}
+
+// merge adds every path below other to n.
+func (n *pathNode) merge(other *pathNode) {
+	n.end = n.end || other.end
+	if other.variable != nil {
+		if n.variable == nil {
+			n.variable, n.placeholder = newPathNode(), other.placeholder
+		}
+		n.variable.merge(other.variable)
+	}
+	for seg, child := range other.children {
+		if n.variable != nil {
+			n.variable.merge(child)
+			continue
+		}
+		if c, ok := n.children[seg]; ok {
+			c.merge(child)
+		} else {
+			n.children[seg] = child
+		}
+	}
+}
+
+// size returns the number of nodes of the tree below n, n included.
+func (n *pathNode) size() int {
+	size := 1
+	if n.variable != nil {
+		size += n.variable.size()
+	}
+	for _, child := range n.children {
+		size += child.size()
+	}
+	return size
+}
+
+// collapse merges the children of n into its variable child.
+func (n *pathNode) collapse() {
+	segs := make([]string, 0, len(n.children))
+	for seg := range n.children {
+		segs = append(segs, seg)
+	}
+
+	if n.variable == nil {
+		n.variable, n.placeholder = newPathNode(), placeholder(segs)
+	}
+	for _, seg := range segs {
+		n.variable.merge(n.children[seg])
+	}
+	n.children = make(map[string]*pathNode)
+}
+
+// placeholder names the kind of values a segment position holds.
+func placeholder(segs []string) string {
+	for _, kind := range []struct {
+		re   *regexp.Regexp
+		name string
+	}{
+		{numericSegment, ":id"},
+		{uuidSegment, ":uuid"},
+		{hashSegment, ":hash"},
+	} {
+		matched := 0
+		for _, seg := range segs {
+			if kind.re.MatchString(seg) {
+				matched++
+			}
+		}
+		// Allow a few odd values such as "new" among numeric ids.
+		if matched*10 >= len(segs)*9 {
+			return kind.name
+		}
+	}
+	return ":param"
+}
+
+// pathLearner learns which segments of the paths of every server_name hold
+// high cardinality values, and replaces them with placeholders. The nodes of
+// every server_name share a budget, paths which would exceed it end with "*".
+type pathLearner struct {
+	mu        sync.Mutex
+	threshold int
+	maxNodes  int
+	nodes     int
+	servers   map[string]*pathNode
+}
+
+func newPathLearner(c client.PathLearningConfig) *pathLearner {
+	threshold := c.Threshold
+	if threshold == 0 {
+		threshold = client.DefaultPathLearningThreshold
+	}
+	maxNodes := c.MaxNodes
+	if maxNodes == 0 {
+		maxNodes = client.DefaultPathLearningMaxNodes
+	}
+	return &pathLearner{
+		threshold: threshold,
+		maxNodes:  maxNodes,
+		servers:   make(map[string]*pathNode),
+	}
+}
+
+// Learn records path and returns its template.
+func (l *pathLearner) Learn(serverName, path string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	root, ok := l.servers[serverName]
+	if !ok {
+		if l.nodes >= l.maxNodes {
+			return "/*"
+		}
+		root = newPathNode()
+		l.servers[serverName] = root
+		l.nodes++
+	}
+
+	segs := strings.Split(path, "/")
+	n := root
+	for i, seg := range segs {
+		if seg == "" {
+			continue
+		}
+
+		if n.variable == nil {
+			child, ok := n.children[seg]
+			if !ok {
+				if l.nodes >= l.maxNodes {
+					return strings.Join(append(segs[:i], "*"), "/")
+				}
+				child = newPathNode()
+				n.children[seg] = child
+				l.nodes++
+				if len(n.children) > l.threshold {
+					// the merged children no longer count
+					size := n.size()
+					n.collapse()
+					l.nodes += n.size() - size
+				}
+			}
+			if n.variable == nil {
+				n = child
+				continue
+			}
+		}
+
+		segs[i] = n.placeholder
+		n = n.variable
+	}
+	n.end = true
+	return strings.Join(segs, "/")
+}
+
+// Templates returns the learned templates holding at least one placeholder
+// by server_name.
+func (l *pathLearner) Templates() map[string][]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	templates := make(map[string][]string)
+	for serverName, root := range l.servers {
+		var paths []string
+		walkTemplates(root, "", false, &paths)
+		if len(paths) > 0 {
+			sort.Strings(paths)
+			templates[serverName] = paths
+		}
+	}
+	return templates
+}
+
+func walkTemplates(n *pathNode, prefix string, templated bool, paths *[]string) {
+	if n.variable != nil {
+		walkTemplates(n.variable, prefix+"/"+n.placeholder, true, paths)
+	}
+	for seg, child := range n.children {
+		walkTemplates(child, prefix+"/"+seg, templated, paths)
+	}
+	if templated && n.end {
+		*paths = append(*paths, prefix)
+	}
+}
+
+// Save writes the learned templates as json to path.
+func (l *pathLearner) Save(path string) error {
+	data, err := json.MarshalIndent(l.Templates(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,108 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zhexuany/esm-filter/client"
+)
+
+func TestPathLearner_Learn(t *testing.T) {
+	l := newPathLearner(client.PathLearningConfig{Threshold: 3})
+
+	for _, p := range []string{"/orders/1/items", "/orders/2/items", "/orders/3"} {
+		if got := l.Learn("a", p); got != p {
+			t.Errorf("Expected %s below the threshold but found %s", p, got)
+		}
+	}
+
+	tests := []struct {
+		path, exp string
+	}{
+		{"/orders/4/items", "/orders/:id/items"},
+		{"/orders/5", "/orders/:id"},
+		{"/orders/1/items", "/orders/:id/items"},
+		{"/ping", "/ping"},
+	}
+	for _, tt := range tests {
+		if got := l.Learn("a", tt.path); got != tt.exp {
+			t.Errorf("%s: Expected %s but found %s", tt.path, tt.exp, got)
+		}
+	}
+
+	// Every server_name learns on its own.
+	if got := l.Learn("b", "/orders/4"); got != "/orders/4" {
+		t.Errorf("Expected %s but found %s", "/orders/4", got)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Learn("b", fmt.Sprintf("/files/%032x", 0xabcdef+i))
+		l.Learn("b", fmt.Sprintf("/tags/tag-%d", i))
+	}
+	exp := map[string][]string{
+		"a": {"/orders/:id", "/orders/:id/items"},
+		"b": {"/files/:hash", "/tags/:param"},
+	}
+	if got := l.Templates(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected templates %v but found %v", exp, got)
+	}
+}
+
+func TestTemplatesCommand(t *testing.T) {
+	l := newPathLearner(client.PathLearningConfig{Threshold: 1})
+	l.Learn("a", "/users/1/name")
+	l.Learn("a", "/users/2/name")
+	l.Learn("b", "/users/3/name")
+	l.Learn("b", "/users/4/name")
+
+	path := filepath.Join(t.TempDir(), "templates.json")
+	if err := l.Save(path); err != nil {
+		t.Fatalf("failed to save templates: %s", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := &TemplatesCommand{Stdout: &stdout, Stderr: &stderr}
+	if err := cmd.Run("-file", path); err != nil {
+		t.Fatalf("failed to print templates: %s", err)
+	}
+
+	exp := "# a, b\n[[path-rules]]\n  pattern = \"^/users/[^/]+/name$\"\n  replacement = \"/users/:id/name\"\n"
+	if got := stdout.String(); strings.TrimSpace(got) != strings.TrimSpace(exp) {
+		t.Errorf("Expected %q but found %q", exp, got)
+	}
+
+	// The printed rule template the paths the same way.
+	m, err := NewMapper(&client.Config{PathRules: []client.PathRuleConfig{
+		{Pattern: templatePattern("/users/:id/name"), Replacement: "/users/:id/name"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+	if got := m.normalizePath("c", "/users/5/name"); got != "/users/:id/name" {
+		t.Errorf("Expected %s but found %s", "/users/:id/name", got)
+	}
+}
+
+func TestPathLearner_MaxNodes(t *testing.T) {
+	l := newPathLearner(client.PathLearningConfig{Threshold: 100, MaxNodes: 4})
+
+	// the root and the segments of the first path use the whole budget
+	tests := []struct {
+		serverName, path, exp string
+	}{
+		{"a", "/orders/1/items", "/orders/1/items"},
+		{"a", "/orders/1", "/orders/1"},
+		{"a", "/orders/2/items", "/orders/*"},
+		{"a", "/users", "/*"},
+		{"b", "/orders/1", "/*"},
+	}
+	for _, tt := range tests {
+		if got := l.Learn(tt.serverName, tt.path); got != tt.exp {
+			t.Errorf("%s%s: Expected %s but found %s", tt.serverName, tt.path, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// pathRule rewrites the paths matching re.
+type pathRule struct {
+	re          *regexp.Regexp
+	replacement string
+
+	rewritten int64
+}
+
+// pathNormalizer collapses high cardinality paths, such as "/orders/812733",
+// before they become part of a series key.
+type pathNormalizer struct {
+	stripQueryString bool
+	rules            []*pathRule
+}
+
+func newPathNormalizer(c *client.Config) (*pathNormalizer, error) {
+	n := &pathNormalizer{stripQueryString: c.StripQueryString}
+	for _, rc := range c.PathRules {
+		re, err := regexp.Compile(rc.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("path rule %q: %s", rc.Pattern, err)
+		}
+		n.rules = append(n.rules, &pathRule{re: re, replacement: rc.Replacement})
+	}
+	return n, nil
+}
+
+// Normalize strips the query string if configured and applies the first
+// rule matching path, it reports whether a rule matched.
+func (n *pathNormalizer) Normalize(path string) (string, bool) {
+	if n.stripQueryString {
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+	}
+
+	for _, r := range n.rules {
+		if r.re.MatchString(path) {
+			rewritten := r.re.ReplaceAllString(path, r.replacement)
+			// a rule keeping paths as they are still stops the others
+			if rewritten != path {
+				atomic.AddInt64(&r.rewritten, 1)
+			}
+			return rewritten, true
+		}
+	}
+	return path, false
+}
+
+// Statistics returns how many paths each rule rewrote.
+func (n *pathNormalizer) Statistics(tags map[string]string) []models.Statistic {
+	stats := make([]models.Statistic, 0, len(n.rules))
+	for _, r := range n.rules {
+		stats = append(stats, models.Statistic{
+			Name: "esm_filter_path_rule",
+			Tags: models.StatisticTags{"pattern": r.re.String(), "replacement": r.replacement}.Merge(tags),
+			Values: map[string]interface{}{
+				"rewritten": atomic.LoadInt64(&r.rewritten),
+			},
+		})
+	}
+	return stats
+}
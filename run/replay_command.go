@@ -0,0 +1,260 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	influxDBClient "github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// replayBatchSize is the number of lines handed to a single mapper.
+const replayBatchSize = 1000
+
+// ReplayCommand recomputes the windows of captured line protocol, grouping
+// points by their own timestamps rather than by time of arrival.
+type ReplayCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func NewReplayCommand() *ReplayCommand {
+	return &ReplayCommand{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// fileList collects every -file flag.
+type fileList []string
+
+func (l *fileList) String() string     { return strings.Join(*l, ",") }
+func (l *fileList) Set(v string) error { *l = append(*l, v); return nil }
+
+func (cmd *ReplayCommand) Run(args ...string) error {
+	var files fileList
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.Var(&files, "file", "")
+	window := fs.Duration("window", 0, "")
+	write := fs.Bool("write", false, "")
+	configPath := fs.String("config", "", "")
+	fs.SetOutput(cmd.Stderr)
+	fs.Usage = func() { fmt.Fprintln(cmd.Stderr, replayUsage) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files = append(files, fs.Args()...)
+
+	opt := Options{ConfigPath: *configPath}
+	config, err := client.ParseConfig(opt.GetConfigPath())
+	if err != nil {
+		return fmt.Errorf("parse config: %s", err)
+	}
+	if err := config.ApplyEnvOverrides(); err != nil {
+		return fmt.Errorf("apply env config: %v", err)
+	}
+
+	if *window == 0 {
+		*window = config.BaseWindow()
+	}
+	if *window <= 0 {
+		return errors.New("window must be positive")
+	}
+	windowTag, rollups := newRollups(config, *window)
+	for _, r := range rollups {
+		if r.size%*window != 0 {
+			return fmt.Errorf("window %s is not a multiple of %s", r.size, *window)
+		}
+	}
+
+	mapper, err := NewMapper(config)
+	if err != nil {
+		return err
+	}
+
+	// the windows are written as the server would write them
+	s := &Server{
+		logOutput: cmd.Stderr,
+		window:    *window,
+		windowTag: windowTag,
+		stampEnd:  config.Timestamp == "end",
+		rollups:   rollups,
+	}
+	if *write {
+		if s.w, err = NewSimplerWriter(config.Downstream); err != nil {
+			return fmt.Errorf("create writer: %s", err)
+		}
+	}
+
+	r := &replay{cmd: cmd, s: s, mapper: mapper}
+	if len(files) == 0 {
+		files = append(files, "-")
+	}
+	for _, path := range files {
+		if err := r.readFile(path); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return r.Close()
+}
+
+// replay streams points into the accumulator of their window, which is
+// written as soon as a point of a later window is read. Points are expected
+// in time order, as they were captured.
+type replay struct {
+	cmd    *ReplayCommand
+	s      *Server
+	mapper *Mapper
+
+	// acc accumulates the points of the window starting at start, nil
+	// until the first point is read
+	acc   *accumulator
+	start time.Time
+	// batch holds the lines not yet handed to acc
+	batch [][]byte
+}
+
+// readFile replays every line of path, "-" reads stdin. Gzipped input is
+// detected by its magic number.
+func (r *replay) readFile(path string) error {
+	var rd io.Reader = r.cmd.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rd = f
+	}
+
+	br := bufio.NewReader(rd)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), client.DefaultTCPBufferSize*16)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		points, err := models.ParsePoints(line)
+		if err != nil {
+			fmt.Fprintf(r.cmd.Stderr, "%s:%d: %s\n", path, lineNo, err)
+			continue
+		}
+		for _, p := range points {
+			start := windowStart(p.Time(), r.s.window)
+			switch {
+			case r.acc == nil:
+				r.acc, r.start = newAccumulator(r.mapper), start
+			case start.Before(r.start):
+				fmt.Fprintf(r.cmd.Stderr, "%s:%d: skipped as window %s is already written, input must be in time order\n", path, lineNo, start)
+				continue
+			case start.After(r.start):
+				if err := r.flush(); err != nil {
+					return err
+				}
+				r.acc, r.start = newAccumulator(r.mapper), start
+			}
+
+			r.batch = append(r.batch, []byte(p.String()))
+			if len(r.batch) == replayBatchSize {
+				r.addBatch()
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// addBatch hands the pending lines to a single mapper.
+func (r *replay) addBatch() {
+	if len(r.batch) == 0 {
+		return
+	}
+	r.acc.Add(&message{buf: bytes.Join(r.batch, []byte("\n"))})
+	r.batch = r.batch[:0]
+}
+
+// flush writes the current window along with the coarser windows it
+// completes.
+func (r *replay) flush() error {
+	r.addBatch()
+	closed := []window{{start: r.start, results: r.acc.Close()}}
+	return r.write(r.s.closedPoints(closed))
+}
+
+// Close writes the last window, and the coarser windows the input ends in
+// as no later point will complete them.
+func (r *replay) Close() error {
+	if r.acc == nil {
+		return nil
+	}
+	if err := r.flush(); err != nil {
+		return err
+	}
+	return r.write(r.s.rollupPoints(time.Unix(0, math.MaxInt64)))
+}
+
+// write prints points as line protocol, or writes them downstream with
+// -write.
+func (r *replay) write(points []*influxDBClient.Point) error {
+	if r.s.w == nil {
+		for _, p := range points {
+			fmt.Fprintln(r.cmd.Stdout, p.String())
+		}
+		return nil
+	}
+
+	bp, err := influxDBClient.NewBatchPoints(newBatchPointsConfig())
+	if err != nil {
+		return err
+	}
+	bp.AddPoints(points)
+	if err := r.s.w.write(bp); err != nil {
+		return fmt.Errorf("write window %s: %s", r.start, err)
+	}
+	return nil
+}
+
+var replayUsage = `Recomputes the windows of captured line protocol.
+
+Usage: esm-filter replay [flags] [files]
+
+    -file <path>
+            Read line protocol from the file, may be repeated.
+            Gzipped files are detected. Stdin is read if no file
+            is given or if the path is "-".
+    -window <duration>
+            Size of the windows points are grouped in by their own
+            timestamps. Defaults to the smallest window of the
+            configuration. The input must be in time order, points
+            of a window already written are reported and skipped.
+            The coarser windows, the "window" tag and the timestamp
+            of the configuration apply as they do in the server.
+    -write
+            Write the aggregates to the downstream of the configuration
+            instead of printing them as line protocol.
+    -config <path>
+            Set the path to the configuration file, it sets how points
+            are grouped and the downstream used by -write.
+`
@@ -0,0 +1,92 @@
+package run
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReplayCommand_Run(t *testing.T) {
+	lines := "requests,host=a,server_name=s,path=/ping,status_code=200 response_time=0.001 1481175440000000000\n" +
+		"requests,host=a,server_name=s,path=/ping,status_code=503 response_time=0.003 1481175449999999999\n" +
+		"not line protocol\n" +
+		"requests,host=a,server_name=s,path=/ping,status_code=200 response_time=0.002 1481175450000000000\n"
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(lines))
+	w.Close()
+
+	var stdout, stderr bytes.Buffer
+	cmd := &ReplayCommand{Stdin: &gz, Stdout: &stdout, Stderr: &stderr}
+	if err := cmd.Run("-window", "10s"); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+
+	out := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(out) != 2 {
+		t.Fatalf("Expected %d aggregates but found %d: %s", 2, len(out), stdout.String())
+	}
+	if !regexp.MustCompile(`totalRequestTimes="?2`).MatchString(out[0]) || !strings.HasSuffix(out[0], " 1481175440000000000") {
+		t.Errorf("Expected the first window to hold 2 requests but found %s", out[0])
+	}
+	if !regexp.MustCompile(`totalRequestTimes="?1`).MatchString(out[1]) || !strings.HasSuffix(out[1], " 1481175450000000000") {
+		t.Errorf("Expected the second window to hold 1 request but found %s", out[1])
+	}
+	if !strings.Contains(stderr.String(), "-:3:") {
+		t.Errorf("Expected the invalid line to be reported but found %q", stderr.String())
+	}
+}
+
+func TestReplayCommand_Windows(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "esm-filter.conf")
+	if err := ioutil.WriteFile(config, []byte(`timestamp = "end"
+
+[[windows]]
+  duration = "10s"
+
+[[windows]]
+  duration = "20s"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := "requests,server_name=s,path=/ping response_time=0.001 1481175440000000000\n" +
+		"requests,server_name=s,path=/ping response_time=0.001 1481175450000000000\n" +
+		"requests,server_name=s,path=/ping response_time=0.001 1481175449000000000\n" +
+		"requests,server_name=s,path=/ping response_time=0.001 1481175460000000000\n"
+
+	var stdout, stderr bytes.Buffer
+	cmd := &ReplayCommand{Stdin: strings.NewReader(lines), Stdout: &stdout, Stderr: &stderr}
+	if err := cmd.Run("-config", config); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+
+	// the windows are stamped with their end, 20s windows once complete
+	exp := []struct {
+		window, requests, time string
+	}{
+		{"10s", "1", "1481175450000000000"},
+		{"10s", "1", "1481175460000000000"},
+		{"20s", "2", "1481175460000000000"},
+		{"10s", "1", "1481175470000000000"},
+		// the input ends in this 20s window
+		{"20s", "1", "1481175480000000000"},
+	}
+	out := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(out) != len(exp) {
+		t.Fatalf("Expected %d aggregates but found %d: %s", len(exp), len(out), stdout.String())
+	}
+	for i, e := range exp {
+		if !strings.Contains(out[i], ",window="+e.window+" ") || !regexp.MustCompile(`totalRequestTimes="?`+e.requests+`\b`).MatchString(out[i]) || !strings.HasSuffix(out[i], " "+e.time) {
+			t.Errorf("%d: Expected %s requests of a %s window at %s but found %s", i, e.requests, e.window, e.time, out[i])
+		}
+	}
+	if !strings.Contains(stderr.String(), "-:3:") {
+		t.Errorf("Expected the point read out of order to be reported but found %q", stderr.String())
+	}
+}
@@ -0,0 +1,116 @@
+package run
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// rollup merges the aggregates of the base windows into coarser windows,
+// which costs as much as a base window whatever the number of points.
+type rollup struct {
+	mu   sync.Mutex
+	size time.Duration
+
+	open map[int64]map[string]RequestStatReducer
+}
+
+func newRollup(size time.Duration) *rollup {
+	return &rollup{
+		size: size,
+		open: make(map[int64]map[string]RequestStatReducer),
+	}
+}
+
+// newRollups returns the "window" tag of the base windows of size base,
+// empty unless windows are configured, and the rollups of the coarser ones.
+func newRollups(c *client.Config, base time.Duration) (string, []*rollup) {
+	var (
+		tag     string
+		rollups []*rollup
+	)
+	for _, wc := range c.Windows {
+		tag = windowName(base)
+		if time.Duration(wc.Duration) == base {
+			continue
+		}
+		rollups = append(rollups, newRollup(time.Duration(wc.Duration)))
+	}
+	return tag, rollups
+}
+
+// Add merges the aggregates of the base window starting at start.
+func (r *rollup) Add(start time.Time, results map[string]RequestStatReducer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	coarse := windowStart(start, r.size).UnixNano()
+	res, ok := r.open[coarse]
+	if !ok {
+		res = make(map[string]RequestStatReducer)
+		r.open[coarse] = res
+	}
+
+	for _, value := range results {
+		// base keys may hold the start of event-time windows
+		key := string(models.MakeKey([]byte(value.measurement), models.NewTags(value.tags)))
+		va, ok := res[key]
+		if !ok {
+			va = RequestStatReducer{measurement: value.measurement, tags: value.tags, window: coarse, spec: value.spec}
+			va.fields = make(map[string]interface{})
+		}
+		va.Merge(value)
+		res[key] = va
+	}
+}
+
+// Flush removes and returns the windows ending at end or before, oldest
+// first.
+func (r *rollup) Flush(end time.Time) []window {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var closed []window
+	for start, res := range r.open {
+		if time.Unix(0, start).Add(r.size).After(end) {
+			continue
+		}
+		closed = append(closed, window{start: time.Unix(0, start).UTC(), results: res})
+		delete(r.open, start)
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].start.Before(closed[j].start) })
+	return closed
+}
+
+// windowName formats the size of a window for the "window" tag, "10s",
+// "5m" or "1h".
+func windowName(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return d.String()
+}
+
+// tagWindow returns the aggregates of results tagged with the window name.
+func tagWindow(results map[string]RequestStatReducer, name string) map[string]RequestStatReducer {
+	tagged := make(map[string]RequestStatReducer, len(results))
+	for key, value := range results {
+		tags := make(map[string]string, len(value.tags)+1)
+		for k, v := range value.tags {
+			tags[k] = v
+		}
+		tags["window"] = name
+		value.tags = tags
+		tagged[key] = value
+	}
+	return tagged
+}
@@ -0,0 +1,58 @@
+package run
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	itoml "github.com/influxdata/influxdb/toml"
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestRollup(t *testing.T) {
+	m, err := NewMapper(&client.Config{Windowing: "event-time", Windows: []client.WindowConfig{
+		{Duration: itoml.Duration(10 * time.Second)},
+		{Duration: itoml.Duration(time.Minute)},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+	r := newRollup(time.Minute)
+
+	// Six base windows of a minute and the first one of the next.
+	for i := 0; i < 7; i++ {
+		inputChan := make(chan interface{})
+		go func(i int) {
+			start := int64(i) * int64(10*time.Second)
+			inputChan <- &message{buf: []byte(
+				"requests,path=/a response_time=0.1 " + strconv.FormatInt(start, 10) + "\n" +
+					"requests,path=/a response_time=0.3 " + strconv.FormatInt(start+1, 10) + "\n")}
+			close(inputChan)
+		}(i)
+		res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+		r.Add(time.Unix(int64(i*10), 0), res)
+	}
+
+	if closed := r.Flush(time.Unix(50, 0)); len(closed) != 0 {
+		t.Fatalf("Expected no window to end before a minute but found %d", len(closed))
+	}
+	closed := r.Flush(time.Unix(70, 0))
+	if len(closed) != 1 || !closed[0].start.Equal(time.Unix(0, 0)) {
+		t.Fatalf("Expected the first minute to close but found %v", closed)
+	}
+
+	rsr := closed[0].results["requests,path=/a"]
+	fields := rsr.Fields()
+	if fields["totalRequestTimes"] != uint64(12) || fields["max"] != 0.3 || fields["min"] != 0.1 {
+		t.Errorf("Expected 12 requests between 0.1 and 0.3 but found %v", fields)
+	}
+
+	points, err := aggregatePoints(tagWindow(closed[0].results, windowName(time.Minute)), closed[0].start)
+	if err != nil || len(points) != 1 {
+		t.Fatalf("Expected a single point but found %v: %v", points, err)
+	}
+	if tags := points[0].Tags(); tags["window"] != "1m" || tags["path"] != "/a" {
+		t.Errorf("Expected the window tag 1m but found %v", tags)
+	}
+}
@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	influxDBClient "github.com/influxdata/influxdb/client/v2"
@@ -21,18 +22,41 @@ type Server struct {
 
 	Logger *log.Logger
 
-	client *client.Client
+	inputs   []*input
+	mapper   *Mapper
+	windows  *eventWindows // nil unless points are bucketed by event time
+	messages chan *message
+	wg       sync.WaitGroup
 
 	logOutput io.Writer
 
-	points influxDBClient.BatchPoints
-
 	err     chan error
 	closing chan struct{}
 
-	ticker *time.Ticker
+	mu sync.Mutex
+	// done is closed once Run has flushed the last window
+	done chan struct{}
+	// rotations, if set, receives the ends of the windows in place of the
+	// clock
+	rotations chan time.Time
+
+	// window is the size of the windows points are reduced in, windowTag
+	// its "window" tag which is empty unless windows are configured
+	window    time.Duration
+	windowTag string
+	// stampEnd stamps aggregates with the end of their window
+	stampEnd bool
+	rollups  []*rollup
+
+	// mapOptions bound the workers of the mapreduce job of every window,
+	// which all count their points in mapStats
+	mapOptions []mapreduce.Option
+	mapStats   *mapreduce.Stats
 
 	w writer
+	// statsWriter writes the statistics, nil unless they are enabled
+	statsWriter writer
+	statsPrefix string
 
 	downstream string
 
@@ -44,12 +68,63 @@ func NewServer(c *client.Config) *Server {
 	if err != nil {
 		return nil
 	}
-	//TODO need add this in config
-	BPConfog := influxDBClient.BatchPointsConfig{
-		Precision:        "s",
-		Database:         "sla",
-		RetentionPolicy:  "",
-		WriteConsistency: "one",
+	var inputs []*input
+	for _, ic := range c.InputConfigs() {
+		in, err := client.NewInput(&ic)
+		if err != nil {
+			return nil
+		}
+		dec, err := newDecoder(&ic)
+		if err != nil {
+			return nil
+		}
+		inputs = append(inputs, &input{name: ic.Name, decoder: dec, Input: in})
+	}
+	mapper, err := NewMapper(c)
+	if err != nil {
+		return nil
+	}
+	var statsWriter writer
+	if c.Statistics.Enabled {
+		downstream := c.Statistics.Downstream
+		if downstream == "" {
+			downstream = c.Downstream
+		}
+		if statsWriter, err = NewSimplerWriter(downstream); err != nil {
+			return nil
+		}
+	}
+	BPConfog := newBatchPointsConfig()
+
+	var windows *eventWindows
+	if c.Windowing == "event-time" {
+		lateness := time.Duration(c.AllowedLateness)
+		if lateness == 0 {
+			lateness = client.DefaultAllowedLateness
+		}
+		skew := time.Duration(c.AllowedSkew)
+		if skew == 0 {
+			skew = client.DefaultAllowedSkew
+		}
+		windows = newEventWindows(c.BaseWindow(), lateness, skew)
+	}
+
+	windowTag, rollups := newRollups(c, c.BaseWindow())
+
+	mapStats := &mapreduce.Stats{}
+	mapOptions := []mapreduce.Option{
+		mapreduce.WithWorkers(c.MapReduce.Workers),
+		mapreduce.WithQueueDepth(c.MapReduce.QueueDepth),
+		mapreduce.WithStats(mapStats),
+	}
+	switch c.MapReduce.Overflow {
+	case "drop-newest":
+		mapOptions = append(mapOptions, mapreduce.WithOverflow(mapreduce.DropNewest))
+	case "drop-oldest":
+		mapOptions = append(mapOptions, mapreduce.WithOverflow(mapreduce.DropOldest))
+	}
+	if c.MapReduce.Ordered {
+		mapOptions = append(mapOptions, mapreduce.Ordered())
 	}
 
 	return &Server{
@@ -58,11 +133,35 @@ func NewServer(c *client.Config) *Server {
 		err:         make(chan error),
 		closing:     make(chan struct{}),
 		logOutput:   os.Stderr,
-		client:      client.NewClient(c),
-		ticker:      time.NewTicker(c.Ticket * time.Second),
+		inputs:      inputs,
+		mapper:      mapper,
+		windows:     windows,
+		messages:    make(chan *message),
 		downstream:  c.Downstream,
 		w:           w,
 		BPConfig:    BPConfog,
+
+		window:    c.BaseWindow(),
+		windowTag: windowTag,
+		stampEnd:  c.Timestamp == "end",
+		rollups:   rollups,
+
+		mapOptions: mapOptions,
+		mapStats:   mapStats,
+
+		statsWriter: statsWriter,
+		statsPrefix: c.Statistics.Prefix,
+	}
+}
+
+// newBatchPointsConfig returns the config of the batches written downstream.
+func newBatchPointsConfig() influxDBClient.BatchPointsConfig {
+	//TODO need add this in config
+	return influxDBClient.BatchPointsConfig{
+		Precision:        "s",
+		Database:         "sla",
+		RetentionPolicy:  "",
+		WriteConsistency: "one",
 	}
 }
 
@@ -76,72 +175,292 @@ func (s *Server) SetLogOutput(w io.Writer) error {
 
 // Open is a function which open server instance.
 func (s *Server) Open() error {
-	s.points, _ = influxDBClient.NewBatchPoints(s.BPConfig)
-	if err := s.client.Open(); err != nil {
-		return fmt.Errorf("failed to open udpClient to read", err)
+	for _, in := range s.inputs {
+		if err := in.Open(); err != nil {
+			return fmt.Errorf("failed to open input %q to read: %s", in.name, err)
+		}
+	}
+
+	for _, in := range s.inputs {
+		s.wg.Add(1)
+		go s.read(in)
 	}
 	return nil
 }
 
-// Run will keep read from port and buffer the results
-func (s *Server) Run() {
-	stopChan := make(chan bool, 1)
-	inputChan := make(chan interface{})
-	go s.filter(inputChan, stopChan)
-	for _ = range s.ticker.C {
-		stopChan <- true
-		stopChan = make(chan bool, 1)
-		inputChan = make(chan interface{})
-		go s.filter(inputChan, stopChan)
-	}
+// input is a client.Input together with the name it is declared with and
+// the decoder of its payloads.
+type input struct {
+	name    string
+	decoder decoder
+	client.Input
 }
 
-func (s *Server) filter(inputChan chan interface{}, stopChan chan bool) {
-	go func() {
-		results := mapreduce.MapReduce(mapper, reducer, inputChan)
-		//every key and value is a point
-		if res, ok := results.(map[string]RequestStatReducer); ok {
-			for key, value := range res {
-				tags := make(map[string]string)
-				tagValueStr := strings.Split(key, ",")
-				if len(tagValueStr) == 4 {
-					tags["host"] = tagValueStr[1]
-					tags["server_name"] = tagValueStr[2]
-					tags["path"] = tagValueStr[3]
-				}
+// message is a payload read from one of the inputs.
+type message struct {
+	input   string
+	decoder decoder
+	buf     []byte
+	// source is the address buf was received from, if the input knows it
+	source string
+	// weighted is set for the points of StatsD, whose "count" field is the
+	// number of requests they stand for
+	weighted bool
+}
 
-				p, err := influxDBClient.NewPoint(tagValueStr[0], tags, value.Fields(), time.Now().UTC())
-				if err != nil {
-					s.logOutput.Write([]byte("failed to parse points"))
-				}
-				s.points.AddPoint(p)
+// statistician is implemented by inputs which keep counters.
+type statistician interface {
+	Statistics(tags map[string]string) []models.Statistic
+}
+
+// Statistics returns the counters of every input and of the mapper, they
+// are written after each window when statistics are enabled.
+func (s *Server) Statistics() []models.Statistic {
+	stats := s.mapper.Statistics(nil)
+	if s.windows != nil {
+		stats = append(stats, s.windows.Statistics(nil)...)
+	}
+	if s.mapStats != nil {
+		stats = append(stats, models.Statistic{
+			Name: "esm_filter_mapreduce",
+			Tags: models.StatisticTags{},
+			Values: map[string]interface{}{
+				"mapped":        atomic.LoadInt64(&s.mapStats.Mapped),
+				"blocked":       atomic.LoadInt64(&s.mapStats.Blocked),
+				"droppedNewest": atomic.LoadInt64(&s.mapStats.DroppedNewest),
+				"droppedOldest": atomic.LoadInt64(&s.mapStats.DroppedOldest),
+			},
+		})
+	}
+	for _, in := range s.inputs {
+		st, ok := in.Input.(statistician)
+		if !ok {
+			continue
+		}
+		tags := make(map[string]string)
+		if in.name != "" {
+			tags["input"] = in.name
+		}
+		stats = append(stats, st.Statistics(tags)...)
+	}
+	return stats
+}
+
+// read keeps reading from in and hands every payload to the current window.
+func (s *Server) read(in *input) {
+	defer s.wg.Done()
+	sr, _ := in.Input.(client.SourceReader)
+	_, weighted := in.Input.(*client.StatsDClient)
+	for {
+		var (
+			buf    []byte
+			source string
+			err    error
+		)
+		if sr != nil {
+			var addr net.Addr
+			if buf, addr, err = sr.ReadFrom(); addr != nil {
+				source = addr.String()
 			}
+		} else {
+			buf, err = in.Read()
 		}
+		if err != nil {
+			select {
+			case <-s.closing:
+				return
+			default:
+			}
+			if err == client.ErrClientClosed {
+				return
+			}
+			s.logOutput.Write([]byte(err.Error()))
+			continue
+		}
+
+		select {
+		case s.messages <- &message{input: in.name, decoder: in.decoder, buf: buf, source: source, weighted: weighted}:
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// flushQueueSize is the number of closed windows waiting to be flushed
+// before the ingest loop blocks.
+const flushQueueSize = 16
 
-		bp := s.points
-		go s.w.write(bp)
-		s.points, _ = influxDBClient.NewBatchPoints(s.BPConfig)
+// Run reads the messages of every input into the accumulator of the
+// current window, and swaps it for a new one at the end of every window.
+// A single goroutine flushes the closed accumulators in order, so nothing
+// they write is shared with the ingest loop.
+func (s *Server) Run() {
+	s.mu.Lock()
+	if s.done != nil {
+		s.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	s.done = done
+	s.mu.Unlock()
+
+	flushes := make(chan *accumulator, flushQueueSize)
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for acc := range flushes {
+			s.flush(acc.end, acc.Close())
+		}
+	}()
+	defer func() {
+		close(flushes)
+		<-flushed
+		close(done)
 	}()
 
-	//keep read until inputChan is nil
+	acc := newAccumulator(s.mapper, s.mapOptions...)
 	for {
-		buf, err := s.client.Read()
-		if err != nil {
-			s.logOutput.Write([]byte(err.Error()))
-		} else {
+		// windows end at multiples of their size, so that the windows of
+		// every instance line up
+		end := windowStart(time.Now(), s.window).Add(s.window)
+		// the clock is left out when the rotations are given
+		var (
+			timer *time.Timer
+			tick  <-chan time.Time
+		)
+		if s.rotations == nil {
+			timer = time.NewTimer(time.Until(end))
+			tick = timer.C
+		}
+
+	window:
+		for {
 			select {
-			case _, ok := <-stopChan:
-				//if stopChan is received, just terminate this goroutine
-				//otherwise, we keep send buf to inputChan
-				if ok {
-					return
+			case m := <-s.messages:
+				acc.Add(m)
+			case <-tick:
+				break window
+			case end = <-s.rotations:
+				break window
+			case <-s.closing:
+				// the partial window is flushed on shutdown
+				if timer != nil {
+					timer.Stop()
 				}
-			case inputChan <- buf:
+				acc.end = end.UTC()
+				flushes <- acc
+				return
 			}
 		}
+
+		acc.end = end.UTC()
+		flushes <- acc
+		acc = newAccumulator(s.mapper, s.mapOptions...)
+	}
+}
+
+// flush writes the aggregates of the window ending at end, along with the
+// coarser windows it completes, and the statistics if they are enabled.
+func (s *Server) flush(end time.Time, results map[string]RequestStatReducer) {
+	var closed []window
+	if s.windows == nil {
+		closed = []window{{start: end.Add(-s.window), results: results}}
+	} else {
+		// event-time aggregates are written once their window closes
+		s.windows.Add(end, results)
+		closed = s.windows.Flush(end)
+	}
+
+	points := s.closedPoints(closed)
+	bp, err := influxDBClient.NewBatchPoints(s.BPConfig)
+	if err != nil {
+		s.Logger.Printf("failed to create batch: %s", err)
+	} else {
+		bp.AddPoints(points)
+		if err := s.w.write(bp); err != nil {
+			s.Logger.Printf("failed to write window %s: %s", end, err)
+		}
+	}
+
+	if s.statsWriter != nil {
+		s.writeStatistics()
+	}
+
+	if err := s.mapper.SaveTemplates(); err != nil {
+		s.Logger.Printf("failed to save path templates: %s", err)
 	}
 }
 
+// writeStatistics writes the counters to their own downstream.
+func (s *Server) writeStatistics() {
+	bp, err := influxDBClient.NewBatchPoints(s.BPConfig)
+	if err != nil {
+		s.Logger.Printf("failed to create batch of statistics: %s", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, stat := range s.Statistics() {
+		p, err := influxDBClient.NewPoint(s.statsPrefix+stat.Name, stat.Tags, stat.Values, now)
+		if err != nil {
+			s.logOutput.Write([]byte("failed to create statistic point"))
+			continue
+		}
+		bp.AddPoint(p)
+	}
+	if err := s.statsWriter.write(bp); err != nil {
+		s.Logger.Printf("failed to write statistics: %s", err)
+	}
+}
+
+// closedPoints returns the points of the closed base windows, oldest first,
+// and of the coarser windows they complete. Coarser windows are merged from
+// the base windows, they are complete once every base window they span is
+// closed.
+func (s *Server) closedPoints(closed []window) []*influxDBClient.Point {
+	var points []*influxDBClient.Point
+	for _, w := range closed {
+		points = append(points, s.windowPoints(w, s.window, s.windowTag)...)
+		for _, r := range s.rollups {
+			r.Add(w.start, w.results)
+		}
+	}
+	if len(closed) > 0 {
+		points = append(points, s.rollupPoints(closed[len(closed)-1].start.Add(s.window))...)
+	}
+	return points
+}
+
+// rollupPoints returns the points of the coarser windows ending at end or
+// before.
+func (s *Server) rollupPoints(end time.Time) []*influxDBClient.Point {
+	var points []*influxDBClient.Point
+	for _, r := range s.rollups {
+		for _, w := range r.Flush(end) {
+			points = append(points, s.windowPoints(w, r.size, windowName(r.size))...)
+		}
+	}
+	return points
+}
+
+// windowPoints returns the points of the aggregates of a closed window of
+// the given size, tagged with tag unless it is empty.
+func (s *Server) windowPoints(w window, size time.Duration, tag string) []*influxDBClient.Point {
+	results := w.results
+	if tag != "" {
+		results = tagWindow(results, tag)
+	}
+
+	t := w.start
+	if s.stampEnd {
+		t = w.start.Add(size)
+	}
+	points, err := aggregatePoints(results, t)
+	if err != nil {
+		s.logOutput.Write([]byte("failed to parse points"))
+	}
+	return points
+}
+
 var (
 	ErrFailedWrite           = errors.New("failed to write\n")
 	ErrFailedCreateUDPClient = errors.New("failed to create UDPClient\n")
@@ -183,134 +502,25 @@ func (sw *simpleWriter) write(data interface{}) error {
 func (s *Server) Err() <-chan error { return s.err }
 
 func (s *Server) Close() error {
-	if s.client != nil {
-		return s.client.Close()
-	}
-
 	close(s.closing)
-	return nil
-}
-
-type RequestStatMapper struct {
-	success      bool
-	statusCode   int
-	responseTime float64
-}
-
-func mapper(input interface{}, output chan interface{}) {
-	//parse buf as Points which defined infludb
-	points, err := models.ParsePoints(input.([]byte))
-	if err != nil {
-		panic("failed to parse points")
-	}
-
-	o := make(map[string]RequestStatMapper)
-	for _, p := range points {
-		tags := p.Tags().Map()
-		var status_code int64
-		var err error
-		var host, serverName, mapKey, path, measurement string
-		measurement = p.Name()
-		for k, v := range tags {
-			switch k {
-			case "host":
-				host = v
-			case "server_name":
-				serverName = v
-			case "status_code":
-				status_code, err = strconv.ParseInt(v, 10, 32)
-				if err != nil {
-					fmt.Println("failed to parse int", err)
-				}
-			case "path":
-				path = v
-			}
-		}
 
-		mapKey = measurement + "," + host + "," + serverName + "," + path
-
-		fields := p.Fields()
-		rs := RequestStatMapper{}
-		value, exists := fields["response_time"]
-		if !exists {
-			fmt.Printf("response_time is not in fields")
-		} else {
-			rs.responseTime = value.(float64)
+	var err error
+	for _, in := range s.inputs {
+		if e := in.Close(); e != nil && err == nil {
+			err = e
 		}
-		rs.statusCode = int(status_code)
-		if status_code/400 > 0 {
-			rs.success = false
-		} else {
-			rs.success = true
-		}
-		o[mapKey] = rs
 	}
+	s.wg.Wait()
 
-	output <- o
-}
-
-type RequestStatReducer struct {
-	fields map[string]interface{}
-}
-
-func (rsr *RequestStatReducer) Update(value RequestStatMapper) {
-	if _, existed := rsr.fields["totalRequestTimes"]; !existed {
-		rsr.fields["totalRequestTimes"] = uint64(1)
-	} else {
-		if val, ok := rsr.fields["totalRequestTimes"].(uint64); ok {
-			rsr.fields["totalRequestTimes"] = val + 1
-		}
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done != nil {
+		<-done
 	}
 
-	if !value.success {
-		if _, existed := rsr.fields["totalFailureTimes"]; !existed {
-			rsr.fields["totalFailureTimes"] = uint64(1)
-		} else {
-			if val, ok := rsr.fields["totalFailureTimes"].(uint64); ok {
-				rsr.fields["totalFailureTimes"] = val + 1
-			}
-		}
-	}
-
-	codeStr := fmt.Sprintf("%d", value.statusCode)
-	if _, existed := rsr.fields[codeStr]; !existed {
-		rsr.fields[codeStr] = uint64(1)
-	} else {
-		if val, ok := rsr.fields[codeStr].(uint64); ok {
-			rsr.fields[codeStr] = val + 1
-		}
+	if e := s.mapper.Close(); e != nil && err == nil {
+		err = e
 	}
-
-	if _, existed := rsr.fields["totalResponseTime"]; !existed {
-		rsr.fields["totalResponseTime"] = float64(value.responseTime)
-	} else {
-		if val, ok := rsr.fields["totalResponseTime"].(float64); ok {
-			rsr.fields["totalResponseTime"] = val + value.responseTime
-		}
-	}
-}
-
-func (rsr *RequestStatReducer) Fields() map[string]interface{} {
-	return rsr.fields
-}
-
-//map[string]RequestStatReducer
-func reducer(input chan interface{}, output chan interface{}) {
-	results := map[string]RequestStatReducer{}
-	for matches := range input {
-		for key, value := range matches.(map[string]RequestStatMapper) {
-			va, exists := results[key]
-			if !exists {
-				rsr := RequestStatReducer{}
-				rsr.fields = make(map[string]interface{})
-				rsr.Update(value)
-				results[key] = rsr
-			} else {
-				va.Update(value)
-				results[key] = va
-			}
-		}
-	}
-
-	output <- results
+	return err
 }
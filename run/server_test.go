@@ -2,29 +2,37 @@ package run
 
 import (
 	"fmt"
-	"github.com/zhexuany/esm-filter/mapreduce"
+	"io/ioutil"
+	"log"
 	"math"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	influxDBClient "github.com/influxdata/influxdb/client/v2"
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
 )
 
+var testMapper, _ = NewMapper(&client.Config{})
+
 func TestServer_Run(t *testing.T) {
-	testKey := "requests,qcr-web-proxy-66,restapi.ele.me,/ping"
 	requestTime := 10
-	responseTime := 0.001
 	test := "requests,host=qcr-web-proxy-66,upstream=127.0.0.1:8444,status_code=503,server_name=restapi.ele.me,method=GET,path=/ping response_time=0.001,response_size=227 1481175443530312000"
 
 	inputChan := make(chan interface{})
 
 	go func() {
 		for i := 0; i < requestTime; i++ {
-			inputChan <- []byte(test)
+			inputChan <- &message{buf: []byte(test)}
 		}
 		close(inputChan)
 	}()
 }
 
 func TestServer_MapReduce(t *testing.T) {
-	testKey := "requests,qcr-web-proxy-66,restapi.ele.me,/ping"
+	testKey := "requests,host=qcr-web-proxy-66,path=/ping,server_name=restapi.ele.me"
 	requestTime := 10
 	responseTime := 0.001
 	test := "requests,host=qcr-web-proxy-66,upstream=127.0.0.1:8444,status_code=503,server_name=restapi.ele.me,method=GET,path=/ping response_time=0.001,response_size=227 1481175443530312000"
@@ -33,12 +41,12 @@ func TestServer_MapReduce(t *testing.T) {
 
 	go func() {
 		for i := 0; i < requestTime; i++ {
-			inputChan <- []byte(test)
+			inputChan <- &message{buf: []byte(test)}
 		}
 		close(inputChan)
 	}()
 	fmt.Println("start mapreduce")
-	results := mapreduce.MapReduce(mapper, reducer, inputChan)
+	results := mapreduce.MapReduce(testMapper.Map, reducer, inputChan)
 	fmt.Println("finished mapreduce")
 
 	if res, ok := results.(map[string]RequestStatReducer); ok {
@@ -63,3 +71,209 @@ func TestServer_MapReduce(t *testing.T) {
 		}
 	}
 }
+
+func TestServer_MapReduceBatch(t *testing.T) {
+	testKey := "requests,host=qcr-web-proxy-66,path=/ping,server_name=restapi.ele.me"
+	test := "requests,host=qcr-web-proxy-66,status_code=200,server_name=restapi.ele.me,path=/ping response_time=0.001 1481175443530312000\n" +
+		"requests,host=qcr-web-proxy-66,status_code=503,server_name=restapi.ele.me,path=/ping response_time=0.002 1481175443530312001\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(testMapper.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	value, ok := res[testKey]
+	if !ok {
+		t.Fatalf("Expected key %s in results", testKey)
+	}
+	if value.fields["totalRequestTimes"].(uint64) != 2 {
+		t.Errorf("Expected %d requests but found %d", 2, value.fields["totalRequestTimes"])
+	}
+	if value.fields["totalFailureTimes"].(uint64) != 1 {
+		t.Errorf("Expected %d failures but found %d", 1, value.fields["totalFailureTimes"])
+	}
+}
+
+func TestServer_MapReduceInput(t *testing.T) {
+	testKey := "requests,host=qcr-web-proxy-66,input=edge-1,path=/ping,server_name=restapi.ele.me"
+	test := "requests,host=qcr-web-proxy-66,status_code=200,server_name=restapi.ele.me,path=/ping response_time=0.001 1481175443530312000"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{input: "edge-1", buf: []byte(test)}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(testMapper.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	if _, ok := res[testKey]; !ok {
+		t.Errorf("Expected key %s in results but found %v", testKey, res)
+	}
+}
+
+func TestServer_MapReduceCount(t *testing.T) {
+	testKey := "requests,path=ping,server_name=restapi"
+	test := "requests,server_name=restapi,path=ping,status_code=503 count=5\n" +
+		"requests,server_name=restapi,path=ping,status_code=503 count=2,response_time=0.5\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test), weighted: true}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(testMapper.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	value, ok := res[testKey]
+	if !ok {
+		t.Fatalf("Expected key %s in results but found %v", testKey, res)
+	}
+	if value.fields["totalRequestTimes"].(uint64) != 7 {
+		t.Errorf("Expected %d requests but found %d", 7, value.fields["totalRequestTimes"])
+	}
+	if value.fields["totalResponseTime"].(float64) != 1 {
+		t.Errorf("Expected total response time %f but found %f", 1.0, value.fields["totalResponseTime"])
+	}
+}
+
+// batchWriter hands the batches written by a server to a channel.
+type batchWriter chan influxDBClient.BatchPoints
+
+func (w batchWriter) write(bp interface{}) error {
+	w <- bp.(influxDBClient.BatchPoints)
+	return nil
+}
+
+func TestServer_FlushTimestamp(t *testing.T) {
+	end := time.Unix(1481175450, 0).UTC()
+	for _, stampEnd := range []bool{false, true} {
+		w := make(batchWriter, 1)
+		s := &Server{
+			Logger:    log.New(ioutil.Discard, "", 0),
+			logOutput: ioutil.Discard,
+			mapper:    testMapper,
+			window:    10 * time.Second,
+			stampEnd:  stampEnd,
+			w:         w,
+			BPConfig:  newBatchPointsConfig(),
+		}
+
+		acc := newAccumulator(s.mapper)
+		acc.Add(&message{buf: []byte("requests,path=/a,status_code=200 response_time=0.1")})
+		s.flush(end, acc.Close())
+
+		exp := end.Add(-10 * time.Second)
+		if stampEnd {
+			exp = end
+		}
+		var found bool
+		for _, p := range (<-w).Points() {
+			if p.Name() != "requests" {
+				continue
+			}
+			found = true
+			if !p.Time().Equal(exp) {
+				t.Errorf("stampEnd=%v: Expected the time %s but found %s", stampEnd, exp, p.Time())
+			}
+		}
+		if !found {
+			t.Errorf("stampEnd=%v: Expected an aggregate in the batch", stampEnd)
+		}
+	}
+}
+
+func TestServer_RunRotation(t *testing.T) {
+	const windows, n = 5, 1000
+	w := make(batchWriter)
+	s := &Server{
+		Logger:    log.New(ioutil.Discard, "", 0),
+		logOutput: ioutil.Discard,
+		mapper:    testMapper,
+		messages:  make(chan *message),
+		closing:   make(chan struct{}),
+		rotations: make(chan time.Time),
+		window:    10 * time.Second,
+		w:         w,
+		BPConfig:  newBatchPointsConfig(),
+	}
+
+	// count the requests of every window written until Run returns
+	counted := make(chan map[time.Time]uint64)
+	go func() {
+		requests := make(map[time.Time]uint64)
+		for bp := range w {
+			for _, p := range bp.Points() {
+				if p.Name() != "requests" {
+					continue
+				}
+				v, err := strconv.ParseUint(fmt.Sprint(p.Fields()["totalRequestTimes"]), 10, 64)
+				if err != nil {
+					t.Errorf("Expected a number of requests but found %v", p.Fields()["totalRequestTimes"])
+				}
+				requests[p.Time()] += v
+			}
+		}
+		counted <- requests
+	}()
+
+	ran := make(chan struct{})
+	go func() {
+		s.Run()
+		close(ran)
+	}()
+
+	// both go through the ingest loop, so every message sent before a
+	// rotation belongs to the window it ends
+	start := time.Unix(1481175440, 0).UTC()
+	for i := 0; i < windows; i++ {
+		for j := 0; j < n; j++ {
+			s.messages <- &message{buf: []byte("requests,path=/a,status_code=200 response_time=0.1")}
+		}
+		s.rotations <- start.Add(time.Duration(i+1) * 10 * time.Second)
+	}
+	close(s.closing)
+	<-ran
+	close(w)
+
+	requests := <-counted
+	if len(requests) != windows {
+		t.Fatalf("Expected %d windows but found %v", windows, requests)
+	}
+	for i := 0; i < windows; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Second)
+		if requests[ts] != n {
+			t.Errorf("%s: Expected %d requests but found %d", ts, n, requests[ts])
+		}
+	}
+}
+
+func TestServer_FlushStatistics(t *testing.T) {
+	w, stats := make(batchWriter, 1), make(batchWriter, 1)
+	s := &Server{
+		Logger:    log.New(ioutil.Discard, "", 0),
+		logOutput: ioutil.Discard,
+		mapper:    testMapper,
+		window:    10 * time.Second,
+		w:         w,
+		BPConfig:  newBatchPointsConfig(),
+	}
+
+	// statistics stay out of the aggregates, and are only written if enabled
+	s.flush(time.Unix(1481175450, 0), nil)
+	for _, p := range (<-w).Points() {
+		t.Errorf("Expected no point but found %s", p.Name())
+	}
+
+	s.statsWriter, s.statsPrefix = stats, "internal_"
+	s.flush(time.Unix(1481175460, 0), nil)
+	<-w
+	points := (<-stats).Points()
+	if len(points) == 0 {
+		t.Fatal("Expected statistics to be written")
+	}
+	for _, p := range points {
+		if !strings.HasPrefix(p.Name(), "internal_esm_filter_") {
+			t.Errorf("Expected the prefix of the statistics but found %s", p.Name())
+		}
+	}
+}
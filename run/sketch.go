@@ -0,0 +1,118 @@
+package run
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchAccuracy is the relative error of the quantiles of a quantileSketch.
+const sketchAccuracy = 0.01
+
+// sketchMinValue is the smallest value told apart from zero, 1µs for latencies.
+const sketchMinValue = 1e-6
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// quantileSketch estimates the quantiles of non-negative values such as
+// latencies with a relative error of sketchAccuracy, as DDSketch does: every value is counted in the bucket
+// of its logarithm in base sketchGamma. Sketches merge without losing any
+// accuracy, so they can be built by several mappers and windows.
+type quantileSketch struct {
+	buckets map[int]uint64
+	zeros   uint64
+
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+func newQuantileSketch() *quantileSketch {
+	return &quantileSketch{buckets: make(map[int]uint64)}
+}
+
+// Add counts n requests which took v seconds.
+func (s *quantileSketch) Add(v float64, n uint64) {
+	if n == 0 {
+		return
+	}
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count += n
+	s.sum += v * float64(n)
+
+	if v < sketchMinValue {
+		s.zeros += n
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(v)/sketchLogGamma))] += n
+}
+
+// Merge adds the values counted by other.
+func (s *quantileSketch) Merge(other *quantileSketch) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	if s.count == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if s.count == 0 || other.max > s.max {
+		s.max = other.max
+	}
+	s.count += other.count
+	s.sum += other.sum
+	s.zeros += other.zeros
+	for i, n := range other.buckets {
+		s.buckets[i] += n
+	}
+}
+
+// Quantile returns the value below which a fraction q of the values fall.
+func (s *quantileSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+
+	// the nearest rank, so that the p99 of a few requests is the slowest
+	rank := uint64(math.Ceil(q*float64(s.count))) - 1
+	if rank < s.zeros {
+		return s.min
+	}
+	seen := s.zeros
+
+	indexes := make([]int, 0, len(s.buckets))
+	for i := range s.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	v := s.max
+	for _, i := range indexes {
+		seen += s.buckets[i]
+		if seen > rank {
+			v = 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1)
+			break
+		}
+	}
+	return math.Max(s.min, math.Min(v, s.max))
+}
+
+// Mean returns the mean of the values.
+func (s *quantileSketch) Mean() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
@@ -0,0 +1,121 @@
+package run
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestLatencySketch_Quantile(t *testing.T) {
+	a, b := newQuantileSketch(), newQuantileSketch()
+	// 1ms to 10s, split between two sketches
+	for i := 1; i <= 10000; i++ {
+		if i%2 == 0 {
+			a.Add(float64(i)/1000, 1)
+		} else {
+			b.Add(float64(i)/1000, 1)
+		}
+	}
+	a.Add(0, 0)
+	a.Merge(b)
+
+	if a.min != 0.001 || a.max != 10 || a.count != 10000 {
+		t.Fatalf("Expected min 0.001, max 10 and count 10000 but found %v, %v and %d", a.min, a.max, a.count)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		exp := q * 10
+		if got := a.Quantile(q); math.Abs(got-exp)/exp > 2*sketchAccuracy {
+			t.Errorf("q%v: Expected about %v but found %v", q, exp, got)
+		}
+	}
+	if got := a.Mean(); math.Abs(got-5.0005) > 1e-9 {
+		t.Errorf("Expected a mean of %v but found %v", 5.0005, got)
+	}
+}
+
+func TestRequestStatReducer_Percentiles(t *testing.T) {
+	m, err := NewMapper(&client.Config{Percentiles: []float64{50, 99.9}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	test := "requests,path=/a response_time=0.1\n" +
+		"requests,path=/a response_time=0.2\n" +
+		"requests,path=/a response_time=0.3\n" +
+		"requests,path=/a count=5\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(test), weighted: true}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	rsr := res["requests,path=/a"]
+	fields := rsr.Fields()
+	for k, exp := range map[string]float64{"min": 0.1, "max": 0.3, "mean": 0.2, "p50": 0.2, "p999": 0.3} {
+		v, ok := fields[k].(float64)
+		if !ok || math.Abs(v-exp)/exp > sketchAccuracy {
+			t.Errorf("%s: Expected about %v but found %v", k, exp, fields[k])
+		}
+	}
+	if _, ok := fields["p99"]; ok {
+		t.Error("Expected only the configured percentiles")
+	}
+	if fields["totalRequestTimes"] != uint64(8) {
+		t.Errorf("Expected %d requests but found %v", 8, fields["totalRequestTimes"])
+	}
+}
+
+func TestRequestStatReducer_Histogram(t *testing.T) {
+	m, err := NewMapper(&client.Config{Histogram: client.HistogramConfig{Enabled: true, Buckets: []float64{0.1, 0.5}}})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+
+	// the buckets of two messages are merged, the counter has no latency
+	first := "requests,path=/a response_time=0.05\n" +
+		"requests,path=/a response_time=0.1\n"
+	second := "requests,path=/a response_time=0.3\n" +
+		"requests,path=/a response_time=2\n" +
+		"requests,path=/a count=5\n"
+
+	inputChan := make(chan interface{})
+	go func() {
+		inputChan <- &message{buf: []byte(first)}
+		inputChan <- &message{buf: []byte(second), weighted: true}
+		close(inputChan)
+	}()
+
+	res := mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer)
+	rsr := res["requests,path=/a"]
+	fields := rsr.Fields()
+	if fields["totalRequestTimes"] != uint64(9) {
+		t.Errorf("Expected %d requests but found %v", 9, fields["totalRequestTimes"])
+	}
+	// the buckets are written as integers, which InfluxDB can sum
+	assertWireFields(t, fields, "le_0.1=2i", "le_0.5=3i", "le_inf=4i")
+}
+
+// assertWireFields checks that the line protocol of fields holds every one
+// of exp.
+func assertWireFields(t *testing.T, fields map[string]interface{}, exp ...string) {
+	p, err := models.NewPoint("requests", nil, fields, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed to create point: %s", err)
+	}
+	written := make(map[string]bool)
+	for _, f := range strings.Split(strings.Fields(p.String())[1], ",") {
+		written[f] = true
+	}
+	for _, f := range exp {
+		if !written[f] {
+			t.Errorf("Expected %s in %s", f, p.String())
+		}
+	}
+}
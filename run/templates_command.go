@@ -0,0 +1,113 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zhexuany/esm-filter/client"
+)
+
+// TemplatesCommand prints the path templates learned by a server as path
+// rules, so they can be pinned into the configuration.
+type TemplatesCommand struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func NewTemplatesCommand() *TemplatesCommand {
+	return &TemplatesCommand{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+func (cmd *TemplatesCommand) Run(args ...string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	configPath := fs.String("config", "", "")
+	path := fs.String("file", "", "")
+	fs.SetOutput(cmd.Stderr)
+	fs.Usage = func() { fmt.Fprintln(cmd.Stderr, templatesUsage) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		opt := Options{ConfigPath: *configPath}
+		config, err := client.ParseConfig(opt.GetConfigPath())
+		if err != nil {
+			return fmt.Errorf("parse config: %s", err)
+		}
+		if err := config.ApplyEnvOverrides(); err != nil {
+			return fmt.Errorf("apply env config: %v", err)
+		}
+		*path = config.PathLearning.TemplatesFile
+	}
+	if *path == "" {
+		return errors.New("no templates file is configured, use -file")
+	}
+
+	data, err := ioutil.ReadFile(*path)
+	if err != nil {
+		return err
+	}
+	var templates map[string][]string
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return fmt.Errorf("%s: %s", *path, err)
+	}
+
+	// The same template may be learned for several server_names.
+	servers := make(map[string][]string)
+	for serverName, paths := range templates {
+		for _, p := range paths {
+			servers[p] = append(servers[p], serverName)
+		}
+	}
+	paths := make([]string, 0, len(servers))
+	for p := range servers {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		sort.Strings(servers[p])
+		fmt.Fprintf(cmd.Stdout, "# %s\n", strings.Join(servers[p], ", "))
+		fmt.Fprintln(cmd.Stdout, "[[path-rules]]")
+		fmt.Fprintf(cmd.Stdout, "  pattern = %s\n", strconv.Quote(templatePattern(p)))
+		fmt.Fprintf(cmd.Stdout, "  replacement = %s\n\n", strconv.Quote(p))
+	}
+	return nil
+}
+
+// templatePattern returns the regular expression matching the paths of a
+// template, placeholders match any segment.
+func templatePattern(template string) string {
+	segs := strings.Split(template, "/")
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, ":") {
+			segs[i] = "[^/]+"
+		} else {
+			segs[i] = regexp.QuoteMeta(seg)
+		}
+	}
+	return "^" + strings.Join(segs, "/") + "$"
+}
+
+var templatesUsage = `Prints the learned path templates as path rules.
+
+Usage: esm-filter templates [flags]
+
+    -file <path>
+            Read the templates saved by a server with path learning
+            enabled. Defaults to the templates-file of the configuration.
+    -config <path>
+            Set the path to the configuration file.
+`
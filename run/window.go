@@ -0,0 +1,130 @@
+package run
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// eventWindows keeps the aggregates of the event-time windows which are
+// still open. A window closes once the clock passes its end by the allowed
+// lateness, the requests which arrive for a closed window are dropped, as
+// are the ones of windows starting further ahead of the clock than skew.
+type eventWindows struct {
+	mu       sync.Mutex
+	size     time.Duration
+	lateness time.Duration
+	skew     time.Duration
+
+	open map[int64]map[string]RequestStatReducer
+	// closed is the end of the latest window flushed, windows starting
+	// before it are closed
+	closed int64
+
+	lateDropped  int64
+	earlyDropped int64
+}
+
+func newEventWindows(size, lateness, skew time.Duration) *eventWindows {
+	return &eventWindows{
+		size:     size,
+		lateness: lateness,
+		skew:     skew,
+		open:     make(map[int64]map[string]RequestStatReducer),
+	}
+}
+
+// Add merges the results of a mapreduce job received at now into their
+// windows.
+func (w *eventWindows) Add(now time.Time, results map[string]RequestStatReducer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	horizon := now.Add(w.skew).UnixNano()
+	for key, r := range results {
+		if r.window < w.closed {
+			n, _ := r.fields["totalRequestTimes"].(uint64)
+			atomic.AddInt64(&w.lateDropped, int64(n))
+			continue
+		}
+		// a client with a clock far ahead would keep its window open
+		// until the clock reaches it
+		if r.window > horizon {
+			n, _ := r.fields["totalRequestTimes"].(uint64)
+			atomic.AddInt64(&w.earlyDropped, int64(n))
+			continue
+		}
+
+		res, ok := w.open[r.window]
+		if !ok {
+			res = make(map[string]RequestStatReducer)
+			w.open[r.window] = res
+		}
+		if va, ok := res[key]; ok {
+			va.Merge(r)
+			continue
+		}
+		res[key] = r
+	}
+}
+
+// window is the aggregates of a closed window starting at start.
+type window struct {
+	start   time.Time
+	results map[string]RequestStatReducer
+}
+
+// Flush removes and returns the windows closed at now, oldest first.
+func (w *eventWindows) Flush(now time.Time) []window {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var closed []window
+	watermark := now.Add(-w.lateness)
+	for start, res := range w.open {
+		end := time.Unix(0, start).Add(w.size)
+		if end.After(watermark) {
+			continue
+		}
+		closed = append(closed, window{start: time.Unix(0, start).UTC(), results: res})
+		delete(w.open, start)
+		if end.UnixNano() > w.closed {
+			w.closed = end.UnixNano()
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].start.Before(closed[j].start) })
+	return closed
+}
+
+// windowStart returns the start of the window of size d holding t. Windows
+// are aligned to the Unix epoch, Truncate aligns them to the zero time which
+// only agrees for sizes dividing a day.
+func windowStart(t time.Time, d time.Duration) time.Time {
+	ns := t.UnixNano()
+	ns -= ns % int64(d)
+	if ns > t.UnixNano() {
+		ns -= int64(d)
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+// Statistics returns the number of open windows and of the requests
+// dropped because their window was closed or too far ahead.
+func (w *eventWindows) Statistics(tags map[string]string) []models.Statistic {
+	w.mu.Lock()
+	open := len(w.open)
+	w.mu.Unlock()
+
+	return []models.Statistic{{
+		Name: "esm_filter_windows",
+		Tags: models.StatisticTags{}.Merge(tags),
+		Values: map[string]interface{}{
+			"openWindows":  int64(open),
+			"lateDropped":  atomic.LoadInt64(&w.lateDropped),
+			"earlyDropped": atomic.LoadInt64(&w.earlyDropped),
+		},
+	}}
+}
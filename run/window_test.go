@@ -0,0 +1,77 @@
+package run
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhexuany/esm-filter/client"
+	"github.com/zhexuany/esm-filter/mapreduce"
+)
+
+func TestEventWindows(t *testing.T) {
+	m, err := NewMapper(&client.Config{Windowing: "event-time", Ticket: 10})
+	if err != nil {
+		t.Fatalf("failed to create mapper: %s", err)
+	}
+	w := newEventWindows(10*time.Second, 5*time.Second, time.Minute)
+
+	run := func(now time.Time, test string) {
+		inputChan := make(chan interface{})
+		go func() {
+			inputChan <- &message{buf: []byte(test)}
+			close(inputChan)
+		}()
+		w.Add(now, mapreduce.MapReduce(m.Map, reducer, inputChan).(map[string]RequestStatReducer))
+	}
+
+	// The second window receives a delayed point after the first flush.
+	run(time.Unix(13, 0), "requests,path=/a response_time=0.1 1000000000\n"+
+		"requests,path=/a response_time=0.1 9000000000\n"+
+		"requests,path=/a response_time=0.1 12000000000\n")
+	if closed := w.Flush(time.Unix(14, 0)); len(closed) != 0 {
+		t.Fatalf("Expected no window to close before the allowed lateness but found %d", len(closed))
+	}
+
+	closed := w.Flush(time.Unix(15, 0))
+	if len(closed) != 1 || !closed[0].start.Equal(time.Unix(0, 0)) {
+		t.Fatalf("Expected the first window to close but found %v", closed)
+	}
+	if v := closed[0].results["0 requests,path=/a"].fields["totalRequestTimes"]; v != uint64(2) {
+		t.Errorf("Expected %d requests but found %v", 2, v)
+	}
+
+	// the point stamped in 2100 is dropped rather than kept open
+	run(time.Unix(20, 0), "requests,path=/a response_time=0.1 3000000000\n"+
+		"requests,path=/a response_time=0.1 19000000000\n"+
+		"requests,path=/a response_time=0.1 4102444800000000000\n")
+
+	closed = w.Flush(time.Unix(25, 0))
+	if len(closed) != 1 || !closed[0].start.Equal(time.Unix(10, 0)) {
+		t.Fatalf("Expected the second window to close but found %v", closed)
+	}
+	if v := closed[0].results["10000000000 requests,path=/a"].fields["totalRequestTimes"]; v != uint64(2) {
+		t.Errorf("Expected %d requests but found %v", 2, v)
+	}
+
+	stats := w.Statistics(nil)[0].Values
+	if stats["lateDropped"] != int64(1) || stats["earlyDropped"] != int64(1) || stats["openWindows"] != int64(0) {
+		t.Errorf("Expected 1 late request, 1 early request and no open window but found %v", stats)
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+	for _, tt := range []struct {
+		t    time.Time
+		d    time.Duration
+		want time.Time
+	}{
+		{time.Unix(1481175443, 0), 10 * time.Second, time.Unix(1481175440, 0)},
+		// 7h does not divide a day, so Truncate would not align it to the epoch
+		{time.Unix(1481175443, 0), 7 * time.Hour, time.Unix(1481175443-1481175443%(7*3600), 0)},
+		{time.Unix(-5, 0), 10 * time.Second, time.Unix(-10, 0)},
+	} {
+		if got := windowStart(tt.t, tt.d); !got.Equal(tt.want) {
+			t.Errorf("%s of %s: Expected %s but found %s", tt.d, tt.t, tt.want, got)
+		}
+	}
+}